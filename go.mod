@@ -1,8 +1,11 @@
 module expect
 
+go 1.21
+
 require (
 	github.com/kr/pty v1.1.3
 	github.com/pkg/errors v0.8.1
 	golang.org/x/crypto v0.0.0-20190131182504-b8fe1690c613
-	golang.org/x/sys v0.0.0-20190201152629-afcc84fd7533 // indirect
 )
+
+require golang.org/x/sys v0.0.0-20190201152629-afcc84fd7533
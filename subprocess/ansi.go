@@ -0,0 +1,99 @@
+package subprocess
+
+import (
+	"os"
+	"strings"
+)
+
+// SetStripANSI controls whether terminal escape sequences, such as colors and
+// cursor movement, are removed from the output Expect matches against.
+// Transcripts, taps and forwarding still see them. Unless SetStripANSI is
+// called before Start, stripping is turned on when the child runs with
+// TERM=dumb, taken from the command's environment or else our own, since
+// that already asks for plain output; an explicit call always wins.
+func (s *SubProcess) SetStripANSI(strip bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.stripANSI = strip
+	s.stripANSISet = true
+}
+
+// dumbTerminal reports whether the child will run with TERM=dumb.
+func (s *SubProcess) dumbTerminal() bool {
+	if s.command == nil {
+		return false
+	}
+	if s.command.Env == nil {
+		return os.Getenv("TERM") == "dumb"
+	}
+	term := ""
+	for _, kv := range s.command.Env {
+		if strings.HasPrefix(kv, "TERM=") {
+			// the last one wins, as it does for exec
+			term = kv[len("TERM="):]
+		}
+	}
+	return term == "dumb"
+}
+
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCharset
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+)
+
+// ansiStripper removes escape sequences from a stream, carrying sequences
+// that are split across reads over to the next call.
+type ansiStripper struct {
+	state int
+}
+
+func (a *ansiStripper) strip(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch a.state {
+		case ansiText:
+			if c == 0x1b {
+				a.state = ansiEscape
+				continue
+			}
+			out = append(out, c)
+
+		case ansiEscape:
+			switch c {
+			case '[':
+				a.state = ansiCSI
+			case ']':
+				a.state = ansiOSC
+			case '(', ')', '*', '+', '#':
+				a.state = ansiCharset
+			default:
+				a.state = ansiText
+			}
+
+		case ansiCharset:
+			a.state = ansiText
+
+		case ansiCSI:
+			// parameters and intermediates run until a final byte
+			if c >= 0x40 && c <= 0x7e {
+				a.state = ansiText
+			}
+
+		case ansiOSC:
+			// an operating system command ends with BEL or ESC \
+			if c == 0x07 {
+				a.state = ansiText
+			} else if c == 0x1b {
+				a.state = ansiOSCEscape
+			}
+
+		case ansiOSCEscape:
+			a.state = ansiText
+		}
+	}
+	return out
+}
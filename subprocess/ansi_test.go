@@ -0,0 +1,84 @@
+package subprocess_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+// stripped reports whether the output of a child printing output and then
+// END reaches Expect as want.
+func stripped(t *testing.T, s *subprocess.SubProcess, want string) bool {
+	t.Helper()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ok, _ := s.ExpectWithTimeout(regexp.MustCompile(`^`+regexp.QuoteMeta(want)+`END`), time.Second)
+	return ok
+}
+
+func TestStripANSI(t *testing.T) {
+	for _, tc := range []struct {
+		name, output, want string
+	}{
+		{"plain", "text", "text"},
+		{"color", "\x1b[1;31mred\x1b[0m", "red"},
+		{"cursor", "\x1b[2K\x1b[1Gline", "line"},
+		{"title ended by BEL", "\x1b]0;title\x07text", "text"},
+		{"title ended by ST", "\x1b]0;title\x1b\\text", "text"},
+		{"charset", "\x1b(Btext", "text"},
+		{"two byte", "\x1b=text", "text"},
+	} {
+		s, err := subprocess.NewSubProcess("printf", "%sEND", tc.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetStripANSI(true)
+		if !stripped(t, s, tc.want) {
+			t.Errorf("%s: %q did not come out as %q", tc.name, tc.output, tc.want)
+		}
+	}
+}
+
+func TestStripANSISplitAcrossReads(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", `printf '\033[1;'; sleep 0.1; printf '31mredEND'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetStripANSI(true)
+	if !stripped(t, s, "red") {
+		t.Errorf("a sequence split across reads was not stripped")
+	}
+}
+
+func TestStripANSIDefault(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		env   []string
+		set   bool
+		strip bool
+		want  bool
+	}{
+		{"dumb terminal", []string{"TERM=dumb"}, false, false, true},
+		{"other terminal", []string{"TERM=xterm"}, false, false, false},
+		{"no terminal", []string{}, false, false, false},
+		{"last TERM wins", []string{"TERM=dumb", "TERM=xterm"}, false, false, false},
+		{"explicitly off", []string{"TERM=dumb"}, true, false, false},
+		{"explicitly on", []string{"TERM=xterm"}, true, true, true},
+	} {
+		s, err := subprocess.NewSubProcess("printf", "\x1b[31mredEND")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Cmd().Env = tc.env
+		if tc.set {
+			s.SetStripANSI(tc.strip)
+		}
+		if got := stripped(t, s, "red"); got != tc.want {
+			t.Errorf("%s: output stripped is %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
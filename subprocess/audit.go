@@ -0,0 +1,42 @@
+package subprocess
+
+import (
+	"regexp"
+)
+
+// audit is one AddAuditPattern registration. Only readOutput touches window.
+type audit struct {
+	expression *regexp.Regexp
+	onMatch    func(match []byte)
+	window     []byte
+}
+
+// AddAuditPattern calls onMatch with every occurrence of expression in the
+// output from now on, such as a "PANIC" that should be recorded whenever it
+// shows up, independently of Expect, which still sees and consumes the same
+// output. Handlers run on the goroutine that reads the pty, in the order they
+// were added, so they must return quickly and must not call Expect.
+func (s *SubProcess) AddAuditPattern(expression *regexp.Regexp, onMatch func(match []byte)) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.audits = append(s.audits, &audit{expression: expression, onMatch: onMatch})
+}
+
+// scan runs the audit over the next chunk of output.
+func (a *audit) scan(chunk []byte) {
+	a.window = append(a.window, chunk...)
+	last := 0
+	for _, loc := range a.expression.FindAllIndex(a.window, -1) {
+		if loc[1] == loc[0] {
+			continue
+		}
+		a.onMatch(append([]byte(nil), a.window[loc[0]:loc[1]]...))
+		last = loc[1]
+	}
+	a.window = a.window[last:]
+	if extra := len(a.window) - subscribeWindow; extra > 0 {
+		a.window = a.window[extra:]
+	}
+	// keep the window from pinning an ever growing backing array
+	a.window = append([]byte(nil), a.window...)
+}
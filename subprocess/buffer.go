@@ -0,0 +1,42 @@
+package subprocess
+
+// SetMaxBuffer bounds the output waiting to be matched to n bytes. Once more
+// than that piles up without a match the oldest output is dropped, as if it
+// had been consumed, so a chatty child that is never expected from cannot use
+// up memory. Zero, the default, leaves the buffer unbounded. Output that is
+// dropped is reported to the OnTrim callback.
+func (s *SubProcess) SetMaxBuffer(n int) {
+	s.bufLock.Lock()
+	s.maxBuffer = n
+	dropped, onTrim := s.trimLocked(), s.onTrim
+	s.bufLock.Unlock()
+
+	if dropped > 0 && onTrim != nil {
+		onTrim(dropped)
+	}
+}
+
+// OnTrim registers fn to be told how many bytes were dropped each time
+// SetMaxBuffer's bound is exceeded, including by SetMaxBuffer lowering it. It
+// is usually called from the goroutine that reads the pty, so it must return
+// quickly and must not call back into the SubProcess.
+func (s *SubProcess) OnTrim(fn func(droppedBytes int)) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.onTrim = fn
+}
+
+// trimLocked drops the oldest output beyond maxBuffer and returns how many
+// bytes it dropped.
+func (s *SubProcess) trimLocked() int {
+	if s.maxBuffer <= 0 {
+		return 0
+	}
+	extra := s.buf.Len() - s.maxBuffer
+	if extra <= 0 {
+		return 0
+	}
+	s.rememberLocked(s.buf.Next(extra))
+	s.consumed += extra
+	return extra
+}
@@ -0,0 +1,19 @@
+package subprocess_test
+
+import "testing"
+
+func TestSetMaxBufferReportsTrim(t *testing.T) {
+	s := seeded(t, "0123456789")
+	var dropped []int
+	s.OnTrim(func(n int) { dropped = append(dropped, n) })
+
+	s.SetMaxBuffer(4)
+	if got := string(s.Buffer()); got != "6789" {
+		t.Errorf("buffer is %q, want %q", got, "6789")
+	}
+	// raising the bound drops nothing, so there is nothing to report
+	s.SetMaxBuffer(100)
+	if len(dropped) != 1 || dropped[0] != 6 {
+		t.Errorf("OnTrim was told %v, want [6]", dropped)
+	}
+}
@@ -0,0 +1,67 @@
+package subprocess
+
+import (
+	"bytes"
+)
+
+// SetCapture controls whether the complete raw output of the child is kept,
+// escape sequences and all, for CaptureAll. It is off by default, since the
+// capture grows for as long as the child writes, and must be turned on
+// before Start to cover everything.
+func (s *SubProcess) SetCapture(enabled bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if !enabled {
+		s.capture = nil
+	} else if s.capture == nil {
+		s.capture = &bytes.Buffer{}
+	}
+}
+
+// CaptureAll returns everything the child wrote, for analysis after the
+// fact, such as replaying cursor movement to find what was left on screen.
+// It returns nil until the output has ended, and if SetCapture was not
+// turned on.
+func (s *SubProcess) CaptureAll() *bytes.Reader {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if s.capture == nil || !s.outputEnded {
+		return nil
+	}
+	return bytes.NewReader(s.capture.Bytes())
+}
+
+// SetMaxCapture caps how much output is kept by SetCapture and written to the
+// SetTranscript writer at n bytes each, and how much data, input and output
+// together, goes into SetStructuredTranscript records, so that a runaway
+// child cannot fill memory or disk. Whatever comes after is left out, while
+// Expect goes on seeing all of it; CaptureTruncated reports whether anything
+// was. Zero, the default, means no cap.
+func (s *SubProcess) SetMaxCapture(n int64) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.maxCapture = n
+}
+
+// CaptureTruncated reports whether anything was left out of the capture or a
+// transcript because of SetMaxCapture.
+func (s *SubProcess) CaptureTruncated() bool {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	return s.captureTruncated
+}
+
+// capLocked returns as much of b as still fits under the SetMaxCapture cap,
+// given that written bytes have gone out already, and counts it as written.
+func (s *SubProcess) capLocked(written *int64, b []byte) []byte {
+	if s.maxCapture > 0 && *written+int64(len(b)) > s.maxCapture {
+		left := s.maxCapture - *written
+		if left < 0 {
+			left = 0
+		}
+		b = b[:left]
+		s.captureTruncated = true
+	}
+	*written += int64(len(b))
+	return b
+}
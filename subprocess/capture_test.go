@@ -0,0 +1,65 @@
+package subprocess_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func TestSetMaxCapture(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", "head -c 5000 /dev/zero | tr '\\0' a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcript, structured bytes.Buffer
+	s.SetCapture(true)
+	s.SetMaxCapture(100)
+	s.SetTranscript(&transcript)
+	s.SetStructuredTranscript(&structured)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// CaptureAll turns up once the reader is done, transcripts included
+	var capture *bytes.Reader
+	for deadline := time.Now().Add(5 * time.Second); capture == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("output did not end")
+		}
+		capture = s.CaptureAll()
+	}
+
+	all, _ := io.ReadAll(capture)
+	if len(all) != 100 {
+		t.Errorf("captured %d bytes, want 100", len(all))
+	}
+	// the header comes first and does not count
+	body := transcript.Bytes()
+	if i := bytes.LastIndex(body, []byte("# echo: ")); i >= 0 {
+		body = body[i+bytes.IndexByte(body[i:], '\n')+1:]
+	}
+	if n := len(body); n != 100 {
+		t.Errorf("transcript holds %d bytes of output, want 100", n)
+	}
+	records, err := subprocess.ParseTranscript(&structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := 0
+	for _, r := range records {
+		recorded += len(r.Data)
+	}
+	if recorded != 100 {
+		t.Errorf("structured transcript holds %d bytes, want 100", recorded)
+	}
+	if !s.CaptureTruncated() {
+		t.Error("CaptureTruncated is false")
+	}
+	if len(s.Buffer()) != 5000 {
+		t.Errorf("buffer holds %d bytes, want all 5000", len(s.Buffer()))
+	}
+}
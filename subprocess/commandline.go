@@ -0,0 +1,48 @@
+package subprocess
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// CommandLine returns the command and its arguments as a shell would need
+// them typed, quoting any that contain spaces or special characters. When
+// SetArgv0 has changed the name the child sees, the line starts with
+// "exec -a name" followed by the executable. It returns "" for a SubProcess
+// without a command.
+func (s *SubProcess) CommandLine() string {
+	if s.command == nil {
+		return ""
+	}
+
+	args := s.command.Args
+	words := make([]string, 0, len(args)+3)
+	if len(args) > 0 && args[0] != s.command.Path && args[0] != filepath.Base(s.command.Path) {
+		words = append(words, "exec", "-a", shellQuote(args[0]), shellQuote(s.command.Path))
+	} else if len(args) > 0 {
+		words = append(words, shellQuote(args[0]))
+	}
+	for _, arg := range args[1:] {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes word for a POSIX shell unless it is made up of
+// characters the shell leaves alone.
+func shellQuote(word string) string {
+	if word == "" {
+		return "''"
+	}
+	safe := true
+	for _, c := range word {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=+,@%", c) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return word
+	}
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,90 @@
+package subprocess
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunCommands sends each command as a line and waits up to timeout for prompt
+// to follow it, returning what the child printed between sending the command
+// and the prompt. This includes the echo of the command itself if the pty
+// echoes input. On error, the outputs of the commands that completed are
+// returned with it.
+func (s *SubProcess) RunCommands(commands []string, prompt *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	outputs := make([]string, 0, len(commands))
+	for _, command := range commands {
+		if err := s.SendLine(command); err != nil {
+			return outputs, errors.Wrapf(err, "error sending %q", command)
+		}
+
+		var output string
+		err := s.waitFor(timeout, func(b []byte) int {
+			if loc := s.findLocked(prompt, b); loc != nil {
+				output = string(b[:loc[0]])
+				return loc[1]
+			}
+			return -1
+		})
+		if err != nil {
+			return outputs, errors.Wrapf(err, "error waiting for prompt after %q", command)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// RunToCompletion sends command as a line, waits for done to show that it
+// has finished, then for the output to end and the child to exit, all within
+// timeout. It returns everything the child printed after the command was
+// sent, and its exit code as reported by ExitCode. On error, whatever output
+// arrived is returned with it and the code is -1.
+func (s *SubProcess) RunToCompletion(command string, done *regexp.Regexp, timeout time.Duration) ([]byte, int, error) {
+	if s.command == nil || s.command.Process == nil {
+		return nil, -1, errNotStarted
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	if err := s.SendLine(command); err != nil {
+		return nil, -1, errors.Wrapf(err, "error sending %q", command)
+	}
+
+	var output []byte
+	err := s.waitUntil(context.Background(), deadline.C, 0, func(b []byte) int {
+		if loc := s.findLocked(done, b); loc != nil {
+			output = append([]byte(nil), b[:loc[1]]...)
+			return loc[1]
+		}
+		return -1
+	})
+	if err != nil {
+		return s.Buffer(), -1, errors.Wrapf(err, "error waiting for %q to finish", command)
+	}
+
+	// nothing matches, so this only returns once the output ends, and the
+	// ErrClosed it ends with is no failure of RunToCompletion's
+	err = s.await(context.Background(), deadline.C, 0, func([]byte) int {
+		return -1
+	})
+	s.bufLock.Lock()
+	output = append(output, s.buf.Bytes()...)
+	s.consumed += s.buf.Len()
+	s.buf.Reset()
+	s.bufLock.Unlock()
+	if err != ErrClosed {
+		s.recordResult(err)
+		return output, -1, errors.Wrapf(err, "error waiting for the output of %q to end", command)
+	}
+
+	select {
+	case <-s.done:
+	case <-deadline.C:
+		s.recordResult(ErrTimeout)
+		return output, -1, errors.Wrapf(ErrTimeout, "error waiting for %q to exit", command)
+	}
+	return output, s.ExitCode(), nil
+}
@@ -0,0 +1,37 @@
+package subprocess_test
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func TestRunToCompletion(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	output, code, err := s.RunToCompletion("echo hi; echo DONE; exit 4", regexp.MustCompile(`(?m)^DONE\r?$`), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 4 {
+		t.Errorf("exit code is %d, want 4", code)
+	}
+	if !bytes.Contains(output, []byte("hi")) {
+		t.Errorf("output is %q", output)
+	}
+	// the output having ended is how RunToCompletion knows it is done, not
+	// a failure of it
+	if got := s.LastExpectResult(); got != subprocess.Matched {
+		t.Errorf("LastExpectResult is %v, want %v", got, subprocess.Matched)
+	}
+}
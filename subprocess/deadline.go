@@ -0,0 +1,31 @@
+package subprocess
+
+import (
+	"time"
+)
+
+// SetDeadline sets a point in time after which every Expect gives up with
+// ErrTimeout, whatever its own timeout, so that a whole sequence of steps can
+// share one budget. The zero time removes the deadline.
+func (s *SubProcess) SetDeadline(t time.Time) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.deadline = t
+}
+
+// TimeLeft returns how long remains until the SetDeadline deadline, zero if
+// it has passed, and false if no deadline is set.
+func (s *SubProcess) TimeLeft() (time.Duration, bool) {
+	s.bufLock.Lock()
+	deadline := s.deadline
+	s.bufLock.Unlock()
+
+	if deadline.IsZero() {
+		return 0, false
+	}
+	left := time.Until(deadline)
+	if left < 0 {
+		left = 0
+	}
+	return left, true
+}
@@ -0,0 +1,42 @@
+package subprocess
+
+import (
+	"context"
+	"io"
+)
+
+// NewEchoSubProcess returns a SubProcess with no process behind it: whatever
+// is sent comes straight back as output, passed through transform first if it
+// is not nil. It is a fake for testing send and expect sequences. It needs no
+// Start, has no terminal, so Echo, SetEcho and Interact do not apply, and
+// Close ends its output, after which Expect fails with ErrClosed and Wait
+// returns.
+func NewEchoSubProcess(transform func(string) string) *SubProcess {
+	r, w := io.Pipe()
+	s := newSubProcess(context.Background(), nil)
+	s.conn = &echoConn{PipeReader: r, w: w, transform: transform}
+	go s.readOutput()
+	return s
+}
+
+// echoConn turns writes into output for the reader.
+type echoConn struct {
+	*io.PipeReader
+	w         *io.PipeWriter
+	transform func(string) string
+}
+
+func (c *echoConn) Write(p []byte) (int, error) {
+	out := p
+	if c.transform != nil {
+		out = []byte(c.transform(string(p)))
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *echoConn) Close() error {
+	return c.w.Close()
+}
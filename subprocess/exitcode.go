@@ -0,0 +1,49 @@
+package subprocess
+
+import (
+	"os"
+	"syscall"
+)
+
+// SetExitCodeMapper changes how ExitCode, and with it ExpectExit and
+// RunToCompletion, turn the child's exit state into a number. ShellExitCode,
+// the default, RawExitCode and NegativeSignalExitCode cover the usual
+// conventions. Nil restores the default.
+func (s *SubProcess) SetExitCodeMapper(mapper func(*os.ProcessState) int) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.exitCodeMapper = mapper
+}
+
+// ShellExitCode reports the code the child exited with, or 128 plus the
+// signal number if it was killed by a signal, as a shell does.
+func ShellExitCode(state *os.ProcessState) int {
+	if sig, ok := exitSignal(state); ok {
+		return 128 + int(sig)
+	}
+	return state.ExitCode()
+}
+
+// RawExitCode reports the code the child exited with, or -1 if it was
+// killed by a signal, as os.ProcessState.ExitCode does.
+func RawExitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
+
+// NegativeSignalExitCode reports the code the child exited with, or the
+// negated signal number if it was killed by a signal.
+func NegativeSignalExitCode(state *os.ProcessState) int {
+	if sig, ok := exitSignal(state); ok {
+		return -int(sig)
+	}
+	return state.ExitCode()
+}
+
+// exitSignal returns the signal that killed the child, if one did.
+func exitSignal(state *os.ProcessState) (syscall.Signal, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
@@ -0,0 +1,444 @@
+package subprocess
+
+import (
+	"bufio"
+	"context"
+	stderrors "errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExpectAllMatches collects successive, non-overlapping matches of expression
+// as output arrives, returning once it has count of them or the timeout
+// elapses. On timeout the matches found so far are returned with ErrTimeout.
+// Output is consumed up to the end of the last collected match.
+func (s *SubProcess) ExpectAllMatches(expression *regexp.Regexp, count int, timeout time.Duration) ([][]byte, error) {
+	var matches [][]byte
+	var pos, end int
+
+	err := s.waitFor(timeout, func(b []byte) int {
+		for len(matches) < count && pos <= len(b) {
+			loc := s.findLocked(expression, b[pos:])
+			if loc == nil {
+				break
+			}
+			matches = append(matches, append([]byte(nil), b[pos+loc[0]:pos+loc[1]]...))
+			end = pos + loc[1]
+			pos = end
+			if loc[0] == loc[1] {
+				pos++
+			}
+		}
+		if len(matches) < count {
+			return -1
+		}
+		return end
+	})
+
+	if err != nil && len(matches) > 0 {
+		s.bufLock.Lock()
+		s.matchedLocked(end)
+		s.bufLock.Unlock()
+	}
+	return matches, err
+}
+
+// ExpectActive waits for expression for as long as the child keeps producing
+// output, failing with ErrIdleTimeout only once it has been silent for quiet.
+// There is no overall bound, which suits long builds that print progress.
+func (s *SubProcess) ExpectActive(expression *regexp.Regexp, quiet time.Duration) (bool, error) {
+	found := false
+	err := s.waitUntil(context.Background(), nil, quiet, func(b []byte) int {
+		if loc := s.findLocked(expression, b); loc != nil {
+			found = true
+			return loc[1]
+		}
+		return -1
+	})
+	return found, err
+}
+
+// ReadAll waits for the child to close its side of the pty and returns, and
+// consumes, everything that has not been matched yet.
+func (s *SubProcess) ReadAll() (rest []byte, err error) {
+	defer func() {
+		s.recordResult(err)
+	}()
+
+	for {
+		s.bufLock.Lock()
+		readErr, updated := s.readErr, s.updated
+		if readErr != nil {
+			rest = append([]byte(nil), s.buf.Bytes()...)
+			s.consumed += s.buf.Len()
+			s.buf.Reset()
+			s.bufLock.Unlock()
+
+			if isEOF(readErr) {
+				return rest, nil
+			}
+			return rest, errors.Wrap(readErr, "error reading from pty")
+		}
+		s.bufLock.Unlock()
+
+		<-updated
+	}
+}
+
+// ExpectByte waits for any one of the bytes in set, which suits menus driven
+// by single keypresses, and returns the one that appeared first. Output is
+// consumed up to and including it.
+func (s *SubProcess) ExpectByte(set []byte, timeout time.Duration) (byte, error) {
+	var found byte
+	var scanned int
+	err := s.waitFor(timeout, func(b []byte) int {
+		if scanned > len(b) {
+			scanned = 0
+		}
+		for i := scanned; i < len(b); i++ {
+			for _, sentinel := range set {
+				if b[i] == sentinel {
+					found = b[i]
+					return i + 1
+				}
+			}
+		}
+		scanned = len(b)
+		return -1
+	})
+	return found, err
+}
+
+// ExpectChoice waits for the first of expressions to match and returns its
+// index along with its submatches, the whole match being element 0.
+func (s *SubProcess) ExpectChoice(expressions []*regexp.Regexp, timeout time.Duration) (int, [][]byte, error) {
+	var index = -1
+	var submatches [][]byte
+	err := s.waitFor(timeout, func(b []byte) int {
+		for i, r := range expressions {
+			if m, end := s.findSubmatchLocked(r, b); end >= 0 {
+				index, submatches = i, m
+				return end
+			}
+		}
+		return -1
+	})
+	return index, submatches, err
+}
+
+// ExpectPeek waits for expression like ExpectWithTimeout but leaves the
+// buffer untouched, so that the next Expect sees the same output, match
+// included. It is meant for deciding what to expect next.
+func (s *SubProcess) ExpectPeek(expression *regexp.Regexp, timeout time.Duration) (bool, error) {
+	found := false
+	err := s.waitFor(timeout, func(b []byte) int {
+		if s.findLocked(expression, b) != nil {
+			found = true
+			// a match ending at 0 consumes nothing
+			return 0
+		}
+		return -1
+	})
+	return found, err
+}
+
+// ExpectInt waits for expression, which must have exactly one capture group,
+// and parses what the group captured as a decimal integer, e.g. with
+// `version (\d+)`.
+func (s *SubProcess) ExpectInt(expression *regexp.Regexp, timeout time.Duration) (int, error) {
+	if n := expression.NumSubexp(); n != 1 {
+		return 0, errors.Errorf("expression %q has %d capture groups, want 1", expression, n)
+	}
+
+	_, submatches, err := s.ExpectChoice([]*regexp.Regexp{expression}, timeout)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(submatches[1]))
+	if err != nil {
+		return 0, errors.Wrap(err, "error parsing captured number")
+	}
+	return n, nil
+}
+
+// ExpectContext waits for expression and returns the unconsumed output up to
+// and including the match, i.e. the match together with everything that led
+// up to it. That output is then consumed.
+func (s *SubProcess) ExpectContext(expression *regexp.Regexp, timeout time.Duration) ([]byte, error) {
+	var output []byte
+	err := s.waitFor(timeout, func(b []byte) int {
+		if loc := s.findLocked(expression, b); loc != nil {
+			output = append([]byte(nil), b[:loc[1]]...)
+			return loc[1]
+		}
+		return -1
+	})
+	return output, err
+}
+
+// ExpectExit waits up to timeout for the child to exit and fails unless its
+// exit code, as reported by ExitCode, is code. A child killed by a signal
+// exits with 128 plus the signal number.
+func (s *SubProcess) ExpectExit(code int, timeout time.Duration) (err error) {
+	defer func() {
+		s.recordResult(err)
+	}()
+
+	if s.command == nil || s.command.Process == nil {
+		return errNotStarted
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.done:
+	case <-timer.C:
+		return ErrTimeout
+	}
+
+	if actual := s.ExitCode(); actual != code {
+		return errors.Errorf("expected exit code %d, got %d", code, actual)
+	}
+	return nil
+}
+
+// TryExpect checks the output buffered so far for expression once, without
+// waiting for more. A match is consumed as Expect would; no match leaves the
+// buffer alone and returns false, with ErrClosed if no more output can come,
+// and LastExpectResult then reports TimedOut or Errored respectively.
+func (s *SubProcess) TryExpect(expression *regexp.Regexp) (bool, error) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	if end := s.findEndLocked(expression, s.buf.Bytes(), true); end >= 0 {
+		s.matchedLocked(end)
+		s.lastResult = Matched
+		return true, nil
+	}
+	if isEOF(s.readErr) || s.exited {
+		s.lastResult = Errored
+		return false, ErrClosed
+	}
+	// no match yet is what an Expect with no time to wait would time out
+	// with, but polling is not worth a warning every time
+	s.lastResult = TimedOut
+	return false, nil
+}
+
+// FindAll streams the submatches of every occurrence of expression in the
+// output, in order, until the output ends or timeout passes from the call,
+// and then closes the channel. Each occurrence is consumed as Expect would
+// consume it, and empty matches are skipped. The channel must be drained,
+// as the search waits for each value to be received.
+func (s *SubProcess) FindAll(expression *regexp.Regexp, timeout time.Duration) (chan [][]byte, error) {
+	if s.conn == nil {
+		return nil, errNotStarted
+	}
+
+	found := make(chan [][]byte)
+	go func() {
+		defer close(found)
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		// next is the offset into the output the search resumes from, for
+		// when matches are not consumed
+		next := 0
+		for {
+			var submatches [][]byte
+			err := s.waitUntil(context.Background(), timer.C, 0, func(b []byte) int {
+				start := next - s.consumed
+				if start < 0 {
+					start = 0
+				}
+				for _, loc := range expression.FindAllSubmatchIndex(b[start:], -1) {
+					if loc[1] == loc[0] {
+						continue
+					}
+					submatches = copySubmatches(b[start:], loc)
+					next = s.consumed + start + loc[1]
+					return start + loc[1]
+				}
+				return -1
+			})
+			if err != nil {
+				return
+			}
+			found <- submatches
+		}
+	}()
+	return found, nil
+}
+
+// ExpectFramed splits the output into tokens with split, as a bufio.Scanner
+// would, and waits up to timeout for a token that match accepts. It returns a
+// copy of that token and consumes the output up to the end of it, including
+// the tokens before it. Once the output has ended split is called with atEOF
+// set so it can hand over a final token. An error from split ends the wait.
+func (s *SubProcess) ExpectFramed(split bufio.SplitFunc, match func(token []byte) bool, timeout time.Duration) ([]byte, error) {
+	var token []byte
+	var splitErr error
+	err := s.waitFor(timeout, func(b []byte) int {
+		atEOF := isEOF(s.readErr) || s.exited
+		pos := 0
+		for pos < len(b) || atEOF {
+			advance, tok, err := split(b[pos:], atEOF)
+			if err == bufio.ErrFinalToken {
+				// the last token there will be, as far as split is concerned
+				pos += advance
+				if tok != nil && match(tok) {
+					token = append([]byte(nil), tok...)
+					return pos
+				}
+				break
+			}
+			if err != nil {
+				// stop waiting; nothing is consumed
+				splitErr = err
+				return 0
+			}
+			pos += advance
+			if tok != nil && match(tok) {
+				token = append([]byte(nil), tok...)
+				return pos
+			}
+			if advance == 0 {
+				break
+			}
+		}
+		return -1
+	})
+	if splitErr != nil {
+		err = errors.Wrap(splitErr, "error splitting output")
+		s.recordResult(err)
+		return nil, err
+	}
+	return token, err
+}
+
+// Available returns and consumes whatever output is buffered right now,
+// without waiting for more. It returns an empty slice if there is none.
+func (s *SubProcess) Available() []byte {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	available := append([]byte{}, s.buf.Bytes()...)
+	s.consumed += s.buf.Len()
+	s.buf.Reset()
+	return available
+}
+
+// ExpectFull is ExpectExpressionsWithTimeout with every bound at once: it
+// gives up with ctx.Err() once ctx is done, with ErrTimeout once total has
+// elapsed and with ErrIdleTimeout once the child has been silent for idle. A
+// total or idle of zero leaves that bound off. Output that matches always
+// wins; when several bounds are up together ctx takes precedence, then total,
+// then idle.
+func (s *SubProcess) ExpectFull(ctx context.Context, expressions []*regexp.Regexp, total, idle time.Duration) (int, error) {
+	started := time.Now()
+	var deadline <-chan time.Time
+	if total > 0 {
+		timer := time.NewTimer(total)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	index, err := s.expectUntil(ctx, expressions, deadline, idle, true)
+	bounded := stderrors.Is(err, ErrTimeout) || err == ErrIdleTimeout ||
+		err == context.Canceled || err == context.DeadlineExceeded
+	if !bounded {
+		return index, err
+	}
+
+	// select picks at random among the bounds that are up, so settle it here
+	settled := err
+	switch {
+	case ctx.Err() != nil:
+		settled = ctx.Err()
+	case total > 0 && time.Since(started) >= total:
+		settled = timeoutError(expressions, started)
+	}
+	if resultOf(settled) != resultOf(err) {
+		s.recordResult(settled)
+	}
+	return index, settled
+}
+
+// ExpectThen waits for expression and calls action with the match and its
+// submatches while the output is held still, before the match is consumed, so
+// that action sees exactly the state that matched and nothing can change in
+// between. action must be quick and must not call back into the SubProcess,
+// which would deadlock; anything it wants to send is best sent once ExpectThen
+// has returned. If action fails its error is returned and the match is left
+// in place.
+func (s *SubProcess) ExpectThen(expression *regexp.Regexp, timeout time.Duration, action func(match [][]byte) error) error {
+	var actionErr error
+	err := s.waitFor(timeout, func(b []byte) int {
+		submatches, end := s.findSubmatchLocked(expression, b)
+		if end < 0 {
+			return -1
+		}
+		if actionErr = action(submatches); actionErr != nil {
+			return 0
+		}
+		return end
+	})
+	if err != nil {
+		return err
+	}
+	if actionErr != nil {
+		s.recordResult(actionErr)
+	}
+	return actionErr
+}
+
+// DiscardUntil throws away output until expression matches, up to and
+// including the match, so that the next Expect starts after it: the way to
+// skip a login banner or a noisy startup. Unlike ExpectContext it returns
+// none of that output, and it consumes it even with SetConsumeOnMatch(false).
+func (s *SubProcess) DiscardUntil(expression *regexp.Regexp, timeout time.Duration) error {
+	return s.waitFor(timeout, func(b []byte) int {
+		loc := s.findLocked(expression, b)
+		if loc == nil {
+			return -1
+		}
+		// consumed here rather than left to matchedLocked, which would keep
+		// the output when consuming is turned off
+		s.rememberLocked(s.buf.Next(loc[1]))
+		s.consumed += loc[1]
+		return 0
+	})
+}
+
+// ExpectAtEOF waits up to timeout for the child to close its side of the pty
+// and only then matches expression against everything that has not been
+// matched yet, returning the match and its submatches. It is for output that
+// has to be judged as a whole rather than as it streams in. If the output
+// ends without a match, the error says so; if it does not end in time,
+// ErrTimeout is returned.
+func (s *SubProcess) ExpectAtEOF(expression *regexp.Regexp, timeout time.Duration) ([][]byte, error) {
+	var submatches [][]byte
+	ended := false
+	started := time.Now()
+	err := s.waitFor(timeout, func(b []byte) int {
+		if !isEOF(s.readErr) && !s.exited {
+			return -1
+		}
+		ended = true
+		var end int
+		submatches, end = s.findSubmatchLocked(expression, b)
+		return end
+	})
+	switch {
+	case err == ErrTimeout:
+		return nil, timeoutError([]*regexp.Regexp{expression}, started)
+	case err == ErrClosed && ended:
+		return nil, errors.Errorf("output ended without a match for %q", expression)
+	}
+	return submatches, err
+}
@@ -0,0 +1,152 @@
+package subprocess_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+	"expect/subprocess/subprocesstest"
+)
+
+// seeded returns an unstarted SubProcess whose output is b.
+func seeded(t *testing.T, b string) *subprocess.SubProcess {
+	t.Helper()
+	s, err := subprocesstest.New([]byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+const short = 20 * time.Millisecond
+
+func TestExpectConsumesThroughMatch(t *testing.T) {
+	s := seeded(t, "login: alice\r\nPassword: ")
+	if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`login: \w+`), short); !ok {
+		t.Fatal(err)
+	}
+	if got := string(s.Buffer()); got != "\r\nPassword: " {
+		t.Errorf("buffer is %q after the match", got)
+	}
+	if end := s.LastMatchEnd(); end != len("login: alice") {
+		t.Errorf("LastMatchEnd is %d", end)
+	}
+
+	// offsets carry on from what the new seed replaced
+	subprocesstest.SetBuffer(s, []byte("$ "))
+	if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`\$ `), short); !ok {
+		t.Fatal(err)
+	}
+	if end := s.LastMatchEnd(); end != len("login: alice\r\nPassword: $ ") {
+		t.Errorf("LastMatchEnd is %d after reseeding", end)
+	}
+}
+
+func TestExpectNoMatchTimesOut(t *testing.T) {
+	s := seeded(t, "nothing to see")
+	_, err := s.ExpectWithTimeout(regexp.MustCompile(`here`), short)
+	if !errors.Is(err, subprocess.ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if got := string(s.Buffer()); got != "nothing to see" {
+		t.Errorf("buffer is %q after a timeout", got)
+	}
+}
+
+func TestExpectChoiceAndInt(t *testing.T) {
+	s := seeded(t, "warning: disk 91% full\r\nversion 42\r\n")
+	index, submatches, err := s.ExpectChoice([]*regexp.Regexp{
+		regexp.MustCompile(`error: (.*)`),
+		regexp.MustCompile(`warning: disk (\d+)%`),
+	}, short)
+	if err != nil || index != 1 || string(submatches[1]) != "91" {
+		t.Fatalf("got %d, %q, %v", index, submatches, err)
+	}
+
+	n, err := s.ExpectInt(regexp.MustCompile(`version (\d+)`), short)
+	if err != nil || n != 42 {
+		t.Fatalf("got %d, %v, want 42", n, err)
+	}
+	if _, err := s.ExpectInt(regexp.MustCompile(`(\d+)\.(\d+)`), short); err == nil {
+		t.Error("ExpectInt accepted two capture groups")
+	}
+}
+
+func TestExpectAllMatches(t *testing.T) {
+	s := seeded(t, "a1 a2 a3 b")
+	matches, err := s.ExpectAllMatches(regexp.MustCompile(`a\d`), 2, short)
+	if err != nil || len(matches) != 2 || string(matches[1]) != "a2" {
+		t.Fatalf("got %q, %v", matches, err)
+	}
+
+	matches, err = s.ExpectAllMatches(regexp.MustCompile(`a\d`), 5, short)
+	if !errors.Is(err, subprocess.ErrTimeout) || len(matches) != 1 || string(matches[0]) != "a3" {
+		t.Fatalf("got %q, %v, want the one match left with ErrTimeout", matches, err)
+	}
+}
+
+func TestExpectPeekAndAnchored(t *testing.T) {
+	s := seeded(t, "noise prompt> ")
+	if ok, err := s.ExpectPeek(regexp.MustCompile(`prompt> `), short); !ok {
+		t.Fatal(err)
+	}
+	if got := string(s.Buffer()); got != "noise prompt> " {
+		t.Errorf("ExpectPeek consumed output: %q", got)
+	}
+
+	s.SetAnchoredMatching(true)
+	if ok, _ := s.ExpectWithTimeout(regexp.MustCompile(`prompt> `), short); ok {
+		t.Error("anchored match found past the start of the buffer")
+	}
+	if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`noise `), short); !ok {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscardUntil(t *testing.T) {
+	s := seeded(t, "Welcome!\r\nLast login: today\r\n$ ls\r\n")
+	s.SetConsumeOnMatch(false)
+	if err := s.DiscardUntil(regexp.MustCompile(`\$ `), short); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(s.Buffer()); got != "ls\r\n" {
+		t.Errorf("buffer is %q after discarding the banner", got)
+	}
+}
+
+func TestExpectThen(t *testing.T) {
+	s := seeded(t, "id=7 rest")
+	var seen string
+	err := s.ExpectThen(regexp.MustCompile(`id=(\d+)`), short, func(match [][]byte) error {
+		seen = string(match[1])
+		return nil
+	})
+	if err != nil || seen != "7" {
+		t.Fatalf("got %q, %v", seen, err)
+	}
+	if got := string(s.Buffer()); got != " rest" {
+		t.Errorf("buffer is %q after ExpectThen", got)
+	}
+}
+
+func TestTryExpectHistoryAndIgnorePrompt(t *testing.T) {
+	s := seeded(t, "> busy\r\nready\r\n")
+	s.SetIgnorePrompt(regexp.MustCompile(`^> `))
+	if ok, _ := s.TryExpect(regexp.MustCompile(`busy`)); ok {
+		t.Error("TryExpect matched inside an ignored line")
+	}
+	if ok, err := s.TryExpect(regexp.MustCompile(`ready`)); !ok {
+		t.Fatal(err)
+	}
+
+	s = seeded(t, "abc")
+	s.SetHistory(8)
+	if ok, err := s.TryExpect(regexp.MustCompile(`ab`)); !ok {
+		t.Fatal(err)
+	}
+	if ok, err := s.TryExpect(regexp.MustCompile(`bc`)); !ok {
+		t.Errorf("TryExpect missed a match straddling the history: %v", err)
+	}
+}
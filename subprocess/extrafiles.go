@@ -0,0 +1,73 @@
+package subprocess
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// AddExtraFile passes f to the child as an additional open file and returns
+// the descriptor number the child sees it under. Descriptors 0 to 2 are the
+// pty, so the first extra file is fd 3, the next fd 4, and so on in the order
+// they were added, as for a --passphrase-fd style option. It must be called
+// before Start.
+func (s *SubProcess) AddExtraFile(f *os.File) (int, error) {
+	if s.command == nil {
+		return -1, errors.New("no command to pass files to")
+	}
+	if s.conn != nil {
+		return -1, errors.New("process already started")
+	}
+	s.command.ExtraFiles = append(s.command.ExtraFiles, f)
+	return 2 + len(s.command.ExtraFiles), nil
+}
+
+// AddExtraPipe passes the read end of a new pipe to the child, as
+// AddExtraFile does, and returns its descriptor number in the child. Data for
+// it is written with SendToFd and CloseFd ends it.
+func (s *SubProcess) AddExtraPipe() (int, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return -1, errors.Wrap(err, "error creating pipe")
+	}
+	fd, err := s.AddExtraFile(r)
+	if err != nil {
+		_ = r.Close()
+		_ = w.Close()
+		return -1, err
+	}
+	if s.pipes == nil {
+		s.pipes = make(map[int]*os.File)
+	}
+	s.pipes[fd] = w
+	return fd, nil
+}
+
+// SendToFd writes data to the pipe the child reads as fd, which must have
+// come from AddExtraPipe.
+func (s *SubProcess) SendToFd(fd int, data []byte) error {
+	w, ok := s.pipes[fd]
+	if !ok {
+		return errors.Errorf("fd %d is not a pipe from AddExtraPipe", fd)
+	}
+	_, err := w.Write(data)
+	return errors.Wrapf(err, "error writing to fd %d", fd)
+}
+
+// CloseFd closes our end of the pipe the child reads as fd, so that the child
+// reads end of file once it has read what was sent.
+func (s *SubProcess) CloseFd(fd int) error {
+	w, ok := s.pipes[fd]
+	if !ok {
+		return errors.Errorf("fd %d is not a pipe from AddExtraPipe", fd)
+	}
+	return w.Close()
+}
+
+// closeExtraReaders closes our copies of the pipe ends handed to the child,
+// once it has its own.
+func (s *SubProcess) closeExtraReaders() {
+	for fd := range s.pipes {
+		_ = s.command.ExtraFiles[fd-3].Close()
+	}
+}
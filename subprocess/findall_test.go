@@ -0,0 +1,78 @@
+package subprocess_test
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+// findAll runs sh -c script and collects what FindAll streams for pattern.
+func findAll(t *testing.T, script, pattern string, consume bool, timeout time.Duration) [][]string {
+	t.Helper()
+	s, err := subprocess.NewSubProcess("sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetConsumeOnMatch(consume)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	found, err := s.FindAll(regexp.MustCompile(pattern), timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all [][]string
+	for submatches := range found {
+		var strs []string
+		for _, m := range submatches {
+			strs = append(strs, string(m))
+		}
+		all = append(all, strs)
+	}
+	return all
+}
+
+func TestFindAll(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		script  string
+		pattern string
+		consume bool
+		want    string
+	}{
+		{"submatches", `printf 'a=1 b=2 c=3'`, `(\w)=(\d)`, true, `[[a=1 a 1] [b=2 b 2] [c=3 c 3]]`},
+		{"without consuming", `printf 'a=1 b=2 c=3'`, `(\w)=(\d)`, false, `[[a=1 a 1] [b=2 b 2] [c=3 c 3]]`},
+		{"across lines", `printf 'x1\nx2\n'`, `x\d`, true, `[[x1] [x2]]`},
+		{"empty matches skipped", `printf 'axxbx'`, `x*`, true, `[[xx] [x]]`},
+		{"none", `printf 'nothing'`, `\d`, true, `[]`},
+		{"split across reads", `printf 'k=1 k='; sleep 0.1; printf '2'`, `k=\d`, true, `[[k=1] [k=2]]`},
+	} {
+		got := fmt.Sprint(findAll(t, tc.script, tc.pattern, tc.consume, 2*time.Second))
+		if got != tc.want {
+			t.Errorf("%s: FindAll found %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFindAllTimeout(t *testing.T) {
+	started := time.Now()
+	got := findAll(t, `echo n=1; sleep 30`, `n=\d`, true, 200*time.Millisecond)
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("FindAll ran on for %s past its timeout", elapsed)
+	}
+	if len(got) != 1 || got[0][0] != "n=1" {
+		t.Errorf("FindAll found %q before the timeout", got)
+	}
+}
+
+func TestFindAllNotStarted(t *testing.T) {
+	s := seeded(t, "a=1")
+	if _, err := s.FindAll(regexp.MustCompile(`a`), short); err == nil {
+		t.Error("FindAll on an unstarted SubProcess did not fail")
+	}
+}
@@ -0,0 +1,87 @@
+package subprocess_test
+
+import (
+	"bufio"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+	"github.com/pkg/errors"
+)
+
+// lengthPrefixed splits frames made of a length byte followed by that many
+// bytes.
+func lengthPrefixed(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return 0, nil, nil
+	}
+	n := 1 + int(data[0])
+	return n, data[1:n], nil
+}
+
+// finalAt splits words like bufio.ScanWords, stopping at word.
+func finalAt(word string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanWords(data, atEOF)
+		if string(token) == word {
+			return advance, token, bufio.ErrFinalToken
+		}
+		return advance, token, err
+	}
+}
+
+var errBadFrame = errors.New("bad frame")
+
+func TestExpectFramed(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		split  bufio.SplitFunc
+		want   string
+		err    error
+		rest   string
+	}{
+		{"lines", "a\nb\nc", bufio.ScanLines, "b", nil, "c"},
+		{"carriage returns", "a\r\nb\r\n", bufio.ScanLines, "b", nil, ""},
+		{"words", "one two three ", bufio.ScanWords, "two", nil, "three "},
+		{"length prefixed", "\x02hi\x03abc\x01z", lengthPrefixed, "abc", nil, "\x01z"},
+		{"unfinished token", "a\nb", bufio.ScanLines, "", subprocess.ErrTimeout, "a\nb"},
+		{"unfinished frame", "\x05ab", lengthPrefixed, "", subprocess.ErrTimeout, "\x05ab"},
+		{"split error", "a\nb\n", func([]byte, bool) (int, []byte, error) {
+			return 0, nil, errBadFrame
+		}, "", errBadFrame, "a\nb\n"},
+		{"final token", "a b c", finalAt("a"), "", subprocess.ErrTimeout, "a b c"},
+		{"final token matched", "a b c", finalAt("b"), "b", nil, "c"},
+	} {
+		s := seeded(t, tc.output)
+		token, err := s.ExpectFramed(tc.split, func(token []byte) bool {
+			return string(token) == "b" || string(token) == "two" || string(token) == "abc"
+		}, short)
+		if string(token) != tc.want || errors.Cause(err) != tc.err {
+			t.Errorf("%s: ExpectFramed returned %q, %v, want %q, %v", tc.name, token, err, tc.want, tc.err)
+		}
+		if got := string(s.Buffer()); got != tc.rest {
+			t.Errorf("%s: buffer is %q, want %q", tc.name, got, tc.rest)
+		}
+	}
+}
+
+func TestExpectFramedAtEOF(t *testing.T) {
+	s, err := subprocess.NewSubProcess("printf", "a\nlast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the last line has no newline and only becomes a token once the output
+	// has ended
+	token, err := s.ExpectFramed(bufio.ScanLines, func(token []byte) bool {
+		return string(token) == "last"
+	}, 2*time.Second)
+	if string(token) != "last" || err != nil {
+		t.Errorf("ExpectFramed returned %q, %v", token, err)
+	}
+}
@@ -0,0 +1,67 @@
+package subprocess
+
+import (
+	"sync"
+)
+
+// Group starts, waits for and shuts down several SubProcesses together.
+type Group struct {
+	procs []*SubProcess
+}
+
+func NewGroup(procs ...*SubProcess) *Group {
+	return &Group{procs: procs}
+}
+
+func (g *Group) Add(p *SubProcess) {
+	g.procs = append(g.procs, p)
+}
+
+// StartAll starts every process in order. If one fails to start, the ones
+// already running are closed and the start error is returned.
+func (g *Group) StartAll() error {
+	for i, p := range g.procs {
+		if err := p.Start(); err != nil {
+			for _, started := range g.procs[:i+1] {
+				_ = started.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseAll closes every process and returns the first error encountered.
+func (g *Group) CloseAll() error {
+	var first error
+	for _, p := range g.procs {
+		if err := p.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Wait waits for every process to exit. As soon as one exits with an error the
+// others are closed; that first error is returned once all have exited.
+func (g *Group) Wait() error {
+	var first error
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for _, p := range g.procs {
+		wg.Add(1)
+		go func(p *SubProcess) {
+			defer wg.Done()
+			if err := p.Wait(); err != nil {
+				once.Do(func() {
+					first = err
+					_ = g.CloseAll()
+				})
+			}
+		}(p)
+	}
+
+	wg.Wait()
+	return first
+}
@@ -0,0 +1,76 @@
+package subprocess
+
+import (
+	"regexp"
+)
+
+// SetHistory keeps up to n of the most recently consumed bytes for Expect,
+// ExpectWithTimeout, ExpectExpressions and TryExpect to match against in
+// front of the buffer, so that a pattern straddling the end of the previous
+// match is still found. Only matches that reach into output that has not been
+// consumed count, so nothing is reported twice. Zero, the default, keeps no
+// history. It has no effect with anchored matching.
+func (s *SubProcess) SetHistory(n int) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.historySize = n
+	s.trimHistoryLocked()
+}
+
+// rememberLocked adds b, which is about to be consumed, to the history.
+func (s *SubProcess) rememberLocked(b []byte) {
+	if s.historySize <= 0 {
+		return
+	}
+	s.history = append(s.history, b...)
+	s.trimHistoryLocked()
+}
+
+func (s *SubProcess) trimHistoryLocked() {
+	if extra := len(s.history) - s.historySize; extra > 0 {
+		s.history = append(s.history[:0], s.history[extra:]...)
+	}
+}
+
+// findEndLocked is findLocked that also looks back into the history and,
+// if skipIgnored is set, passes over lines matching the SetIgnorePrompt
+// expression. It returns the end of the first match in b, or -1.
+func (s *SubProcess) findEndLocked(expression *regexp.Regexp, b []byte, skipIgnored bool) int {
+	ignore := s.ignorePrompt
+	if !skipIgnored {
+		ignore = nil
+	}
+	if (len(s.history) == 0 || s.anchored) && ignore == nil {
+		if loc := s.findLocked(expression, b); loc != nil {
+			return loc[1]
+		}
+		return -1
+	}
+
+	h := 0
+	text := b
+	if len(s.history) > 0 && !s.anchored {
+		h = len(s.history)
+		text = make([]byte, 0, h+len(b))
+		text = append(append(text, s.history...), b...)
+	}
+
+	spans := [][2]int{{0, len(text)}}
+	if ignore != nil {
+		spans = unignoredSpans(ignore, text)
+	}
+	for _, span := range spans {
+		if s.anchored && span[0] != 0 {
+			break
+		}
+		for _, loc := range expression.FindAllIndex(text[span[0]:span[1]], -1) {
+			if s.anchored && loc[0] != 0 {
+				break
+			}
+			if end := span[0] + loc[1]; end > h {
+				return end - h
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,63 @@
+package subprocess_test
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		history  int
+		anchored bool
+		pattern  string
+		matched  bool
+		rest     string
+	}{
+		{"straddling", 8, false, `cd`, true, "ef"},
+		{"no history", 0, false, `cd`, false, "def"},
+		{"history too short", 1, false, `bcd`, false, "def"},
+		{"history just long enough", 2, false, `bcd`, true, "ef"},
+		{"all in the history", 8, false, `bc`, false, "def"},
+		{"not reported twice", 8, false, `abc|e`, true, "f"},
+		{"anchored", 8, true, `cd`, false, "def"},
+	} {
+		s := seeded(t, "abcdef")
+		s.SetHistory(tc.history)
+		if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`abc`), short); !ok {
+			t.Fatal(err)
+		}
+		s.SetAnchoredMatching(tc.anchored)
+
+		ok, _ := s.ExpectWithTimeout(regexp.MustCompile(tc.pattern), short)
+		if ok != tc.matched {
+			t.Errorf("%s: %q matched %v, want %v", tc.name, tc.pattern, ok, tc.matched)
+		}
+		if got := string(s.Buffer()); got != tc.rest {
+			t.Errorf("%s: buffer is %q, want %q", tc.name, got, tc.rest)
+		}
+	}
+}
+
+func TestHistoryKeepsTheLatest(t *testing.T) {
+	s := seeded(t, "one two three")
+	s.SetHistory(3)
+	for _, p := range []string{`one`, ` two`} {
+		if ok, err := s.ExpectWithTimeout(regexp.MustCompile(p), short); !ok {
+			t.Fatal(err)
+		}
+	}
+	// only "two" is left of what was consumed
+	if ok, _ := s.ExpectWithTimeout(regexp.MustCompile(`e two t`), short); ok {
+		t.Error("matched against history that should have been dropped")
+	}
+	if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`two t`), short); !ok {
+		t.Error(err)
+	}
+
+	// shrinking the history drops its oldest bytes at once
+	s.SetHistory(1)
+	if ok, _ := s.ExpectWithTimeout(regexp.MustCompile(`t th`), short); ok {
+		t.Error("matched against history beyond the new size")
+	}
+}
@@ -0,0 +1,48 @@
+package subprocess
+
+// SetSuppressInputEcho controls whether the pty's echo of what is written to
+// the child is kept out of the output Expect matches against, so that sending
+// "login" cannot be mistaken for the child printing it. Written bytes are
+// remembered as the pty echoes them, with carriage returns and newlines
+// becoming "\r\n", and dropped when they come back at the front of the
+// output. The first byte that differs, such as output the child writes
+// before the echo or any output at all with echo turned off, ends the
+// tracking of everything sent so far. Transcripts, taps and forwarding still
+// see the echo.
+func (s *SubProcess) SetSuppressInputEcho(suppress bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.suppressEcho = suppress
+	s.pendingEcho = nil
+}
+
+// expectEchoLocked records p, about to be written to the child, as echo to
+// be dropped from the output.
+func (s *SubProcess) expectEchoLocked(p []byte) {
+	if !s.suppressEcho || s.forward != nil {
+		return
+	}
+	s.pendingEcho = append(s.pendingEcho, echoOf(p)...)
+}
+
+// stripEchoLocked returns b without the echo at its front.
+func (s *SubProcess) stripEchoLocked(b []byte) []byte {
+	n := 0
+	for n < len(b) && n < len(s.pendingEcho) && b[n] == s.pendingEcho[n] {
+		n++
+	}
+	if n < len(b) {
+		// the output went its own way, so whatever is left will not come back
+		s.pendingEcho = nil
+		return b[n:]
+	}
+	s.pendingEcho = s.pendingEcho[n:]
+	return nil
+}
+
+// InputEchoed reports whether what is sent to the child currently comes back
+// in its output, which is when SetSuppressInputEcho is worth turning on. It
+// is Echo under a name for that question, and like Echo fails before Start.
+func (s *SubProcess) InputEchoed() (bool, error) {
+	return s.Echo()
+}
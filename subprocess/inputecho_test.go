@@ -0,0 +1,41 @@
+package subprocess_test
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuppressInputEcho(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		suppress bool
+		input    string
+		want     string
+	}{
+		{"suppressed", true, "login\n", "login\r\n"},
+		{"not suppressed", false, "login\n", "login\r\nlogin\r\n"},
+		{"carriage return", true, "login\r", "login\r\n"},
+		{"several lines", true, "a\rb\n", "a\r\nb\r\n"},
+		{"empty line", true, "\n", "\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := startCat(t)
+			s.SetSuppressInputEcho(tc.suppress)
+			if err := s.Send(tc.input); err != nil {
+				t.Fatal(err)
+			}
+
+			// what cat prints back is all that is left once the echo is
+			// dropped, so the buffer settles on it and nothing more
+			for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if string(s.Buffer()) == tc.want {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			if got := string(s.Buffer()); got != tc.want {
+				t.Errorf("output is %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
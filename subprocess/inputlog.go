@@ -0,0 +1,50 @@
+package subprocess
+
+import (
+	"regexp"
+)
+
+// redacted replaces whatever a redaction expression matches.
+var redacted = []byte("[redacted]")
+
+// SetLogInput controls whether everything written to the child is logged,
+// one line per write, to the internal log returned by LogOutput and to the
+// debug logger if one is set. Redactions are applied before anything is
+// logged. It is off by default.
+func (s *SubProcess) SetLogInput(enabled bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.logInput = enabled
+}
+
+// AddRedaction hides every match of expression in logged input, and in the
+// input records of SetStructuredTranscript, behind "[redacted]". Each write
+// is redacted on its own, so a secret sent in several writes is only caught
+// if expression matches its pieces. Output is never redacted, so input the
+// pty echoes back still shows up there.
+func (s *SubProcess) AddRedaction(expression *regexp.Regexp) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.redactions = append(s.redactions, expression)
+}
+
+// logInputLocked logs p, about to be written to the child, if input logging
+// is on.
+func (s *SubProcess) logInputLocked(p []byte) {
+	if !s.logInput {
+		return
+	}
+	p = s.redactLocked(p)
+	s.log.Printf("send: %q", p)
+	if s.debug != nil {
+		s.debug.Printf("send: %q", p)
+	}
+}
+
+// redactLocked returns p with the redactions applied.
+func (s *SubProcess) redactLocked(p []byte) []byte {
+	for _, r := range s.redactions {
+		p = r.ReplaceAll(p, redacted)
+	}
+	return p
+}
@@ -0,0 +1,45 @@
+package subprocess_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"expect/subprocess"
+)
+
+func TestRedactionInStructuredTranscript(t *testing.T) {
+	s := subprocess.NewEchoSubProcess(nil)
+	var structured bytes.Buffer
+	s.SetStructuredTranscript(&structured)
+	s.SetLogInput(true)
+	s.AddRedaction(regexp.MustCompile(`hunter2`))
+
+	if err := s.Send("password hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+	_ = s.Wait()
+
+	records, err := subprocess.ParseTranscript(&structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := 0
+	for _, r := range records {
+		if r.Direction != subprocess.Input {
+			continue
+		}
+		inputs++
+		if string(r.Data) != "password [redacted]" {
+			t.Errorf("input recorded as %q", r.Data)
+		}
+	}
+	if inputs != 1 {
+		t.Errorf("%d input records, want 1", inputs)
+	}
+	if strings.Contains(s.LogOutput(), "hunter2") {
+		t.Errorf("log shows the secret: %q", s.LogOutput())
+	}
+}
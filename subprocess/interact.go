@@ -0,0 +1,347 @@
+package subprocess
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+func (s *SubProcess) listenForShutdown(ctx context.Context, tty *os.File, signals chan os.Signal, cancel context.CancelFunc) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGWINCH:
+				if err := s.inheritSize(tty); err != nil {
+					// probably not worth shutting down the process over this error, so let's log and move on
+					s.logf("error resizing pty: %s", err)
+				}
+
+			default:
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (s *SubProcess) waitForCommandCompletion(ctx context.Context, cancel context.CancelFunc) {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- s.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			s.logf("failed with error: %v", err)
+		}
+		cancel()
+	case <-ctx.Done():
+	}
+}
+
+// copyFrom forwards r to the pty until r runs dry, writing fails or ctx is
+// done. A read from r that is already in progress cannot be interrupted, so if
+// ctx ends first copyFrom returns once that read does, without forwarding it.
+func (s *SubProcess) copyFrom(ctx context.Context, r io.Reader) {
+	chunk := make([]byte, 1024)
+	for {
+		n, err := r.Read(chunk)
+		if ctx.Err() != nil {
+			return
+		}
+		if n > 0 {
+			if _, werr := s.Write(chunk[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SetLocalTermMode controls whether Interact puts the caller's terminal in
+// raw mode, which is the default. Raw mode passes every keystroke, including
+// ^C, straight to the child; without it the local terminal keeps its line
+// editing and echo and only hands over complete lines, and ^C interrupts the
+// session instead.
+func (s *SubProcess) SetLocalTermMode(raw bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.localRaw = raw
+}
+
+// Interact connects the child to the caller's terminal until the child
+// exits or the caller is interrupted.
+func (s *SubProcess) Interact() {
+	if err := s.InteractWith(os.Stdin, os.Stdout); err != nil {
+		s.logf("error interacting: %s", err)
+	}
+}
+
+// InteractWith is Interact over arbitrary streams, such as a network
+// connection: input read from stdin goes to the child and the child's output
+// goes to stdout. Raw mode and window size tracking only apply when stdin is a
+// terminal.
+func (s *SubProcess) InteractWith(stdin io.Reader, stdout io.Writer) error {
+	return s.interact(s.ctx, stdin, stdout)
+}
+
+// InteractContext is Interact that also ends when ctx is done, in which case
+// the child is sent SIGTERM, then killed if it has not exited within a few
+// seconds, the terminal is restored and ctx.Err() is returned.
+func (s *SubProcess) InteractContext(ctx context.Context) error {
+	return s.interact(ctx, os.Stdin, os.Stdout)
+}
+
+// setOldState records the terminal state for Close to restore.
+func (s *SubProcess) setOldState(state *terminal.State, fd int) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.oldState, s.oldStateFd = state, fd
+}
+
+// terminalOf returns r and its descriptor if r is a terminal, and nil
+// otherwise.
+func terminalOf(r io.Reader) (*os.File, int) {
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil, -1
+	}
+	fd, err := rawFd(f)
+	if err != nil || !terminal.IsTerminal(fd) {
+		return nil, -1
+	}
+	return f, fd
+}
+
+// OnInteractExit registers handler to run at the end of every interactive
+// session, once the terminal has been restored and the copying has stopped,
+// just before Interact returns. It is passed the session's error, which for a
+// panic describes the panic, and the panic carries on afterwards.
+func (s *SubProcess) OnInteractExit(handler func(err error)) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.onInteractExit = handler
+}
+
+func (s *SubProcess) interact(parent context.Context, stdin io.Reader, stdout io.Writer) (err error) {
+	// deferred first so that it runs last, after all the teardown below
+	defer func() {
+		s.bufLock.Lock()
+		handler := s.onInteractExit
+		s.bufLock.Unlock()
+		if handler == nil {
+			return
+		}
+
+		if r := recover(); r != nil {
+			handler(errors.Errorf("interactive session panicked: %v", r))
+			panic(r)
+		}
+		handler(err)
+	}()
+
+	if s.conn == nil {
+		return errNotStarted
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	notify := []os.Signal{os.Interrupt, syscall.SIGINT, syscall.SIGTSTP}
+
+	// when stdin is a pipe, a file or a socket there is no terminal mode to
+	// change and no window size to follow, so just forward the data
+	tty, fd := terminalOf(stdin)
+	if tty != nil {
+		notify = append(notify, syscall.SIGWINCH)
+		if err := s.inheritSize(tty); err != nil {
+			s.logf("error resizing pty: %s", err)
+		}
+
+		s.bufLock.Lock()
+		raw := s.localRaw
+		s.bufLock.Unlock()
+
+		if raw {
+			oldState, err := terminal.MakeRaw(fd)
+			if err != nil {
+				s.logf("error putting terminal in raw mode: %s", err)
+			} else {
+				s.setOldState(oldState, fd)
+				defer func() {
+					_ = terminal.Restore(fd, oldState)
+					s.setOldState(nil, -1)
+				}()
+			}
+		}
+	}
+	signal.Notify(signals, notify...)
+	defer signal.Stop(signals)
+
+	// the session ends, and ctx is cancelled, when the child exits or we are
+	// interrupted; wg covers every goroutine that is guaranteed to notice
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.listenForShutdown(ctx, tty, signals, cancel)
+	}()
+	go func() {
+		defer wg.Done()
+		s.waitForCommandCompletion(ctx, cancel)
+	}()
+
+	s.setForward(stdout)
+
+	stopCopy := s.startCopy(ctx, stdin)
+
+	<-ctx.Done()
+
+	// a session called off from outside takes the child down with it
+	if parent.Err() != nil {
+		s.stop()
+	}
+
+	// when the child has exited its last output may still be on its way, so
+	// give the reader a moment to reach the end before cutting it off
+	select {
+	case <-s.done:
+		select {
+		case <-s.readDone:
+		case <-time.After(exitDrainPeriod):
+		}
+	default:
+	}
+
+	// closing the pty unblocks the reader and any write still in flight, and
+	// waiting for the reader makes sure no output follows the session ending
+	_ = s.conn.Close()
+	wg.Wait()
+	<-s.readDone
+
+	stopCopy()
+	return parent.Err()
+}
+
+// startCopy starts forwarding stdin to the pty and returns a func that stops
+// it again, leaving whatever has not been read yet in stdin for whoever reads
+// it next. A read from a terminal, which is a blocking descriptor that cannot
+// have a deadline, is only started once poll says there is input, so that it
+// never has to be interrupted. Other readers without a deadline cannot be
+// stopped, and copyFrom gives up on its own once their read returns.
+func (s *SubProcess) startCopy(ctx context.Context, stdin io.Reader) func() {
+	copied := make(chan struct{})
+
+	if f, ok := stdin.(*os.File); ok && f.SetReadDeadline(time.Time{}) != nil {
+		fd, fdErr := rawFd(f)
+		wakeR, wakeW, pipeErr := os.Pipe()
+		if fdErr == nil && pipeErr == nil {
+			go func() {
+				defer close(copied)
+				s.copyPolled(fd, wakeR)
+			}()
+			return func() {
+				_ = wakeW.Close()
+				<-copied
+				_ = wakeR.Close()
+			}
+		}
+		if pipeErr == nil {
+			_ = wakeR.Close()
+			_ = wakeW.Close()
+		}
+	}
+
+	go func() {
+		defer close(copied)
+		s.copyFrom(ctx, stdin)
+	}()
+
+	type readDeadliner interface {
+		SetReadDeadline(t time.Time) error
+	}
+	return func() {
+		if d, ok := stdin.(readDeadliner); ok && d.SetReadDeadline(time.Now()) == nil {
+			<-copied
+			_ = d.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// copyPolled forwards input from fd to the pty until wake becomes readable,
+// which it does once its other end is closed, or reading or writing fails.
+func (s *SubProcess) copyPolled(fd int, wake *os.File) {
+	wakeFd, err := rawFd(wake)
+	if err != nil {
+		return
+	}
+
+	chunk := make([]byte, 1024)
+	for {
+		fds := []unix.PollFd{
+			{Fd: int32(fd), Events: unix.POLLIN},
+			{Fd: int32(wakeFd), Events: unix.POLLIN},
+		}
+		if _, err := unix.Poll(fds, -1); err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+		if fds[0].Revents == 0 {
+			continue
+		}
+
+		n, err := unix.Read(fd, chunk)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if n > 0 {
+			if _, werr := s.Write(chunk[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// stop sends SIGTERM to a running child and escalates to SIGKILL if it has
+// not exited within killGracePeriod.
+func (s *SubProcess) stop() {
+	if s.command == nil || s.command.Process == nil {
+		return
+	}
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	_ = s.command.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-s.done:
+	case <-time.After(killGracePeriod):
+		_ = s.command.Process.Kill()
+		<-s.done
+	}
+}
@@ -0,0 +1,228 @@
+package subprocess_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+	"github.com/kr/pty"
+	"golang.org/x/sys/unix"
+)
+
+// interactReturned waits for the result of an interactive session, failing
+// the test if it does not end within limit.
+func interactReturned(t *testing.T, result <-chan error, limit time.Duration) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(limit):
+		t.Fatalf("interactive session still running after %s", limit)
+		return nil
+	}
+}
+
+func TestInteractContextCancel(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sleep", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// InteractContext works on the process's own stdin and stdout, so swap
+	// in pipes for the duration
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdinW.Close()
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutR.Close()
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdinR, stdoutW
+	defer func() {
+		os.Stdin, os.Stdout = oldStdin, oldStdout
+		_ = stdinR.Close()
+		_ = stdoutW.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.InteractContext(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancelled := time.Now()
+	cancel()
+	if err := interactReturned(t, result, 2*time.Second); err != context.Canceled {
+		t.Errorf("InteractContext returned %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(cancelled); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s to tear down after cancel", elapsed)
+	}
+
+	select {
+	case <-s.Done():
+	default:
+		t.Error("child still running after the session was cancelled")
+	}
+}
+
+func TestInteractEndsWhenChildExits(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", "read x; echo got $x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stdin, input := net.Pipe()
+	defer input.Close()
+	var stdout bytes.Buffer
+	result := make(chan error, 1)
+	go func() { result <- s.InteractWith(stdin, &stdout) }()
+
+	if _, err := input.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := interactReturned(t, result, 5*time.Second); err != nil {
+		t.Errorf("InteractWith returned %v", err)
+	}
+	if !strings.Contains(stdout.String(), "got hello") {
+		t.Errorf("output is %q", stdout.String())
+	}
+}
+
+func TestInteractEndsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := subprocess.NewSubProcessContext(ctx, "sleep", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stdin, input := net.Pipe()
+	defer input.Close()
+	result := make(chan error, 1)
+	go func() { result <- s.InteractWith(stdin, io.Discard) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := interactReturned(t, result, 2*time.Second); err != context.Canceled {
+		t.Errorf("InteractWith returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestInteractShortLivedChild(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", "echo hi; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdinR.Close()
+	defer stdinW.Close()
+	var stdout bytes.Buffer
+	result := make(chan error, 1)
+	go func() { result <- s.InteractWith(stdinR, &stdout) }()
+
+	if err := interactReturned(t, result, 2*time.Second); err != nil {
+		t.Errorf("InteractWith returned %v", err)
+	}
+	if !strings.Contains(stdout.String(), "hi") {
+		t.Errorf("output is %q, the child's last words were lost", stdout.String())
+	}
+	_ = s.Wait()
+	if code := s.ExitCode(); code != 3 {
+		t.Errorf("ExitCode is %d, want 3", code)
+	}
+
+	// the copy from stdin has been unwound, so input written now is still
+	// there to read rather than swallowed on its way to a dead pty
+	if _, err := stdinW.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	_ = stdinR.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1)
+	if n, err := stdinR.Read(b); n != 1 || err != nil {
+		t.Errorf("stdin read after the session got %d bytes, %v", n, err)
+	}
+}
+
+func TestInteractTerminalStdin(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", "echo hi; exit 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLocalTermMode(false)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	master, slave, err := pty.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	// a terminal inherited as stdin is a blocking descriptor that cannot have
+	// a read deadline, unlike the slave as opened here
+	fd, err := unix.Dup(int(slave.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.NewFile(uintptr(fd), "stdin")
+	defer stdin.Close()
+	if err := stdin.SetReadDeadline(time.Time{}); err == nil {
+		t.Fatal("stdin accepts a read deadline")
+	}
+
+	var stdout bytes.Buffer
+	result := make(chan error, 1)
+	go func() { result <- s.InteractWith(stdin, &stdout) }()
+
+	if err := interactReturned(t, result, 2*time.Second); err != nil {
+		t.Errorf("InteractWith returned %v", err)
+	}
+	if !strings.Contains(stdout.String(), "hi") {
+		t.Errorf("output is %q", stdout.String())
+	}
+
+	// nothing is left reading the terminal, so a line typed now is still
+	// there for the next reader
+	if _, err := master.Write([]byte("x\n")); err != nil {
+		t.Fatal(err)
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	if n, err := unix.Poll(fds, 1000); n != 1 || err != nil {
+		t.Fatalf("nothing to read from the terminal after the session: %v", err)
+	}
+	b := make([]byte, 8)
+	if n, err := stdin.Read(b); n == 0 || b[0] != 'x' {
+		t.Errorf("terminal read after the session got %q, %v", b[:n], err)
+	}
+}
@@ -0,0 +1,7 @@
+// Package testhook lets subprocesstest reach into a SubProcess without the
+// subprocess package exporting anything for it.
+package testhook
+
+// SetBuffer replaces the unmatched output of a *subprocess.SubProcess. The
+// subprocess package sets it when it is initialized.
+var SetBuffer func(s interface{}, b []byte)
@@ -0,0 +1,36 @@
+package subprocess
+
+import (
+	"bytes"
+)
+
+// OnLine calls handler with every complete line of output as it is read,
+// without the line ending, for progress logging and the like. Output after
+// the last newline is held back until the line is complete, or passed on as
+// it is once the output ends. The handler runs on the goroutine that reads
+// the pty, so it should be quick.
+func (s *SubProcess) OnLine(handler func(line string)) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.onLine = handler
+}
+
+// feedLines passes the complete lines in chunk, together with whatever was
+// left over from earlier chunks, to handler. When end is set the output is
+// over and a trailing partial line is flushed too.
+func (s *SubProcess) feedLines(chunk []byte, end bool, handler func(line string)) {
+	s.partialLine = append(s.partialLine, chunk...)
+	for {
+		i := bytes.IndexByte(s.partialLine, '\n')
+		if i < 0 {
+			break
+		}
+		handler(string(bytes.TrimSuffix(s.partialLine[:i], []byte("\r"))))
+		s.partialLine = s.partialLine[i+1:]
+	}
+
+	if end && len(s.partialLine) > 0 {
+		handler(string(bytes.TrimSuffix(s.partialLine, []byte("\r"))))
+		s.partialLine = nil
+	}
+}
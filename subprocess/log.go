@@ -3,17 +3,66 @@ package subprocess
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"sync"
 )
 
+// Logger receives diagnostic messages; *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// debugTail is how much of the end of the buffer debug messages quote.
+const debugTail = 80
+
 type logger struct {
 	logLock sync.RWMutex
 	bytes.Buffer
+	prefix string
 }
 
 func (l *logger) Printf(line string, format ...interface{}) {
 	l.logLock.Lock()
 	defer l.logLock.Unlock()
-	s := fmt.Sprintf(line, format)
-	_, _ = l.Write([]byte(s + "\n"))
+	s := fmt.Sprintf(line, format...)
+	_, _ = l.Write([]byte(l.prefix + s + "\n"))
+}
+
+func (l *logger) setPrefix(prefix string) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	l.prefix = prefix
+}
+
+func (l *logger) String() string {
+	l.logLock.RLock()
+	defer l.logLock.RUnlock()
+	return l.Buffer.String()
+}
+
+// SetDebugLogger sets a logger for verbose diagnostics, such as what Expect was
+// looking at whenever new output failed to match. Nothing is logged without
+// one.
+func (s *SubProcess) SetDebugLogger(l Logger) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.debugBase = l
+	s.debug = s.namedLocked(l)
+}
+
+// debugNoMatchLocked logs the tail of b and the expressions that did not
+// match it. bufLock must be held.
+func (s *SubProcess) debugNoMatchLocked(expressions []*regexp.Regexp, b []byte) {
+	if s.debug == nil {
+		return
+	}
+	patterns := make([]string, len(expressions))
+	for i, r := range expressions {
+		patterns[i] = r.String()
+	}
+	tail := b
+	if len(tail) > debugTail {
+		tail = tail[len(tail)-debugTail:]
+	}
+	s.debug.Printf("expect: %d bytes buffered, no match for %q in tail %q", len(b), patterns, tail)
 }
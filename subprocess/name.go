@@ -0,0 +1,97 @@
+package subprocess
+
+import (
+	"io"
+	"log"
+	"sync"
+)
+
+// SetName labels the SubProcess, for example within a Group: every line
+// logged to LogOutput, the debug logger and the standard logger starts with
+// "name: ", as does every line of the transcript, structured log events carry
+// a name attribute and structured transcript records a name field. The empty
+// name, the default, adds nothing.
+func (s *SubProcess) SetName(name string) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	s.name = name
+	s.log.setPrefix(s.prefixLocked())
+	s.debug = s.namedLocked(s.debugBase)
+	s.transcriptOut = s.namedWriterLocked(s.transcript)
+	if s.records != nil {
+		s.records.setName(name)
+	}
+}
+
+// prefixLocked returns what starts each line logged for the SubProcess.
+func (s *SubProcess) prefixLocked() string {
+	if s.name == "" {
+		return ""
+	}
+	return s.name + ": "
+}
+
+// namedLocked returns l with the name prefix added, if there is one.
+func (s *SubProcess) namedLocked(l Logger) Logger {
+	if l == nil || s.name == "" {
+		return l
+	}
+	return &namedLogger{prefix: s.prefixLocked(), Logger: l}
+}
+
+// namedWriterLocked returns w with the name prefix added to every line, if
+// there is one.
+func (s *SubProcess) namedWriterLocked(w io.Writer) io.Writer {
+	if w == nil || s.name == "" {
+		return w
+	}
+	return &prefixWriter{prefix: []byte(s.prefixLocked()), w: w, lineStart: true}
+}
+
+// logf logs to the standard logger with the name prefix.
+func (s *SubProcess) logf(format string, v ...interface{}) {
+	s.bufLock.Lock()
+	prefix := s.prefixLocked()
+	s.bufLock.Unlock()
+
+	log.Printf("%s"+format, append([]interface{}{prefix}, v...)...)
+}
+
+type namedLogger struct {
+	prefix string
+	Logger
+}
+
+func (l *namedLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf("%s"+format, append([]interface{}{l.prefix}, v...)...)
+}
+
+// prefixWriter writes prefix at the start of every line written through it.
+type prefixWriter struct {
+	mu        sync.Mutex
+	prefix    []byte
+	w         io.Writer
+	lineStart bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []byte
+	for _, c := range b {
+		if p.lineStart {
+			out = append(out, p.prefix...)
+			p.lineStart = false
+		}
+		out = append(out, c)
+		if c == '\n' {
+			p.lineStart = true
+		}
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
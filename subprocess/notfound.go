@@ -0,0 +1,40 @@
+package subprocess
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// NotFoundError is returned when the command to run does not exist, which is
+// usually a typo in its name or a missing package. Err is the error exec
+// reported.
+type NotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("command %q not found: %s", e.Name, e.Err)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// notFound returns err as a *NotFoundError if it says that name does not
+// exist, and err unchanged otherwise.
+func notFound(name string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return &NotFoundError{Name: name, Err: err}
+	}
+	// exec only reports a missing executable as such when it looked it up;
+	// one given by path fails in fork/exec instead
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && pathErr.Op == "fork/exec" && pathErr.Err == syscall.ENOENT {
+		return &NotFoundError{Name: name, Err: err}
+	}
+	return err
+}
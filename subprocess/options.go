@@ -0,0 +1,58 @@
+package subprocess
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Option configures a SubProcess created by NewSubProcessWithOptions.
+type Option func(*SubProcess)
+
+// NewSubProcessWithOptions is NewSubProcess with options applied before the
+// SubProcess is returned.
+func NewSubProcessWithOptions(command string, args []string, opts ...Option) (*SubProcess, error) {
+	s, err := NewSubProcess(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// WithPtyFunc replaces the function Start uses to attach the command to a
+// pseudo-terminal and run it, which by default behaves like pty.Start. It must
+// start cmd and return the master side of the pty. WithSetsid has no effect
+// on a custom function.
+func WithPtyFunc(start func(cmd *exec.Cmd) (*os.File, error)) Option {
+	return func(s *SubProcess) {
+		s.startPty = start
+	}
+}
+
+// WithSetsid controls whether the child is started in a new session with the
+// pty as its controlling terminal, which is the default. Only then does the
+// terminal driver turn a ^C or ^Z written to the pty into a signal for the
+// child's foreground process group. Without it the child stays in the
+// caller's session and process group; both settings map onto Setsid and
+// Setctty in syscall.SysProcAttr, available on Linux and the BSDs including
+// macOS.
+func WithSetsid(setsid bool) Option {
+	return func(s *SubProcess) {
+		s.setsid = setsid
+	}
+}
+
+// WithSigchldDetection makes Expect fail with ErrClosed as soon as the child
+// exits, instead of waiting for the pty to report end of file, which never
+// happens while a background process the child started keeps the terminal
+// open. Exit is noticed by the goroutine that reaps the child, which the
+// kernel wakes at the same moment it would deliver SIGCHLD. No SIGCHLD
+// handler is installed, so the signal, which is process-wide, and the reaping
+// of other children, including those of os/exec, are left alone.
+func WithSigchldDetection(detect bool) Option {
+	return func(s *SubProcess) {
+		s.exitEndsOutput = detect
+	}
+}
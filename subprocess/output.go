@@ -0,0 +1,42 @@
+package subprocess
+
+// OutputChan returns a channel that receives every chunk the child writes
+// from now on, starting with any output that is buffered but has not been
+// matched yet, and is closed once the pty reaches end of file. The output is
+// handed over instead of being buffered, so Expect sees none of it while the
+// channel is in use. The reader waits for each chunk to be received, so the
+// channel must be drained for the child to make progress. Every call returns
+// the same channel.
+func (s *SubProcess) OutputChan() <-chan []byte {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	if s.output != nil {
+		return s.output
+	}
+	s.output = make(chan []byte, 1)
+	if s.buf.Len() > 0 {
+		s.output <- append([]byte(nil), s.buf.Bytes()...)
+		s.consumed += s.buf.Len()
+		s.buf.Reset()
+	}
+	if s.outputEnded {
+		close(s.output)
+	}
+	return s.output
+}
+
+// endOutputChan closes the OutputChan channel and ends subscriptions when
+// the reader is done.
+func (s *SubProcess) endOutputChan() {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	s.outputEnded = true
+	if s.output != nil {
+		close(s.output)
+	}
+	for _, sub := range s.subscribers {
+		sub.feed(nil, true)
+	}
+}
@@ -0,0 +1,74 @@
+package subprocess
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExpectPasswordWithRetries waits up to timeout for prompt and answers it
+// with each of passwords in turn for as long as the prompt comes back. It
+// returns the index of the password after which the prompt stayed away for
+// timeout, whether the child carried on or exited, and an error if the prompt
+// never showed or every password was rejected. Since that means waiting out
+// the timeout even when the first password works, ExpectPasswordWithSuccess
+// is quicker when it is known what success looks like.
+func (s *SubProcess) ExpectPasswordWithRetries(prompt *regexp.Regexp, passwords []string, timeout time.Duration) (int, error) {
+	return s.ExpectPasswordWithSuccess(prompt, nil, passwords, timeout)
+}
+
+// ExpectPasswordWithSuccess is ExpectPasswordWithRetries that takes a
+// password as accepted as soon as success matches, such as a shell prompt
+// or a welcome message, rather than once the prompt has stayed away for
+// timeout. The prompt staying away or the child exiting without success
+// matching is then an error. A nil success behaves like
+// ExpectPasswordWithRetries.
+func (s *SubProcess) ExpectPasswordWithSuccess(prompt, success *regexp.Regexp, passwords []string, timeout time.Duration) (int, error) {
+	promptFound := func(b []byte) int {
+		if loc := s.findLocked(prompt, b); loc != nil {
+			return loc[1]
+		}
+		return -1
+	}
+
+	if err := s.waitFor(timeout, promptFound); err != nil {
+		return -1, errors.Wrap(err, "error waiting for password prompt")
+	}
+	for i, password := range passwords {
+		// a lone carriage return is what pressing Enter sends; SendLine's
+		// "\r\n" would be read as an extra, empty attempt in canonical mode
+		if err := s.Send(password + "\r"); err != nil {
+			return -1, errors.Wrapf(err, "error sending password %d", i)
+		}
+
+		accepted := false
+		err := s.waitFor(timeout, func(b []byte) int {
+			again := s.findLocked(prompt, b)
+			if success != nil {
+				// whichever shows first decides the attempt
+				loc := s.findLocked(success, b)
+				if loc != nil && (again == nil || loc[0] < again[0]) {
+					accepted = true
+					return loc[1]
+				}
+			}
+			if again != nil {
+				return again[1]
+			}
+			return -1
+		})
+		if accepted || (success == nil && (err == ErrTimeout || err == ErrClosed)) {
+			// waiting out the prompt is how success shows without a success
+			// pattern, and should not be reported as a timeout
+			s.recordResult(nil)
+			return i, nil
+		}
+		if err != nil {
+			return -1, errors.Wrapf(err, "error waiting for the result of password %d", i)
+		}
+	}
+	err := errors.Errorf("all %d passwords were rejected", len(passwords))
+	s.recordResult(err)
+	return -1, err
+}
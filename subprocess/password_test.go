@@ -0,0 +1,75 @@
+package subprocess_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+const loginScript = `printf 'Password: '; read p
+while [ "$p" != secret ]; do printf 'Password: '; read p; done
+echo Welcome; sleep 10`
+
+func TestExpectPasswordWithSuccess(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", loginScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	started := time.Now()
+	i, err := s.ExpectPasswordWithSuccess(regexp.MustCompile(`Password: `), regexp.MustCompile(`Welcome`),
+		[]string{"wrong", "secret"}, 5*time.Second)
+	if err != nil || i != 1 {
+		t.Fatalf("got %d, %v, want 1, nil", i, err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("took %s, as if waiting out the timeout", elapsed)
+	}
+	if r := s.LastExpectResult(); r != subprocess.Matched {
+		t.Errorf("LastExpectResult is %s, want %s", r, subprocess.Matched)
+	}
+}
+
+func TestExpectPasswordWithRetries(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", loginScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	i, err := s.ExpectPasswordWithRetries(regexp.MustCompile(`Password: `), []string{"wrong", "secret"}, 300*time.Millisecond)
+	if err != nil || i != 1 {
+		t.Fatalf("got %d, %v, want 1, nil", i, err)
+	}
+	if r := s.LastExpectResult(); r != subprocess.Matched {
+		t.Errorf("LastExpectResult is %s, want %s", r, subprocess.Matched)
+	}
+}
+
+func TestExpectPasswordAllRejected(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", loginScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.ExpectPasswordWithSuccess(regexp.MustCompile(`Password: `), regexp.MustCompile(`Welcome`),
+		[]string{"wrong", "worse"}, 5*time.Second); err == nil {
+		t.Fatal("no error with every password rejected")
+	}
+	if r := s.LastExpectResult(); r != subprocess.Errored {
+		t.Errorf("LastExpectResult is %s, want %s", r, subprocess.Errored)
+	}
+}
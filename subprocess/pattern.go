@@ -0,0 +1,106 @@
+package subprocess
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// patternCacheSize bounds how many compiled patterns are kept for the string
+// based Expect methods. Beyond that the oldest entry is dropped.
+const patternCacheSize = 64
+
+// patternCache holds compiled expressions keyed by their source. Compiled
+// expressions are safe for concurrent use, so it is shared by all
+// SubProcesses.
+var patternCache = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+	order    []string
+}{compiled: make(map[string]*regexp.Regexp)}
+
+// compilePattern returns the compiled form of pattern, compiling it only the
+// first time it is seen.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+
+	if r, ok := patternCache.compiled[pattern]; ok {
+		return r, nil
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(patternCache.order) >= patternCacheSize {
+		delete(patternCache.compiled, patternCache.order[0])
+		patternCache.order = patternCache.order[1:]
+	}
+	patternCache.compiled[pattern] = r
+	patternCache.order = append(patternCache.order, pattern)
+	return r, nil
+}
+
+// ExpectPattern is ExpectWithTimeout for a pattern given as a string. Compiled
+// patterns are cached, so repeating the same pattern costs no recompilation.
+func (s *SubProcess) ExpectPattern(pattern string, timeout time.Duration) (bool, error) {
+	r, err := compilePattern(pattern)
+	if err != nil {
+		return false, err
+	}
+	return s.ExpectWithTimeout(r, timeout)
+}
+
+// globToPattern translates a glob into a regular expression that matches a
+// whole line: * matches any run of characters within the line, ? matches
+// exactly one, and everything else, including [ and ], matches itself. The
+// line must have ended, so a line still being written does not match early,
+// and its \r\n, or a bare \n, is consumed along with it.
+func globToPattern(glob string) string {
+	var b strings.Builder
+	b.WriteString(`(?m)^`)
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(`[^\n]*`)
+		case '?':
+			b.WriteString(`[^\n]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`\r?\n`)
+	return b.String()
+}
+
+// ExpectGlob waits for a complete line matching glob, e.g. "*done*". See
+// globToPattern for the translation rules.
+func (s *SubProcess) ExpectGlob(glob string, timeout time.Duration) (bool, error) {
+	return s.ExpectPattern(globToPattern(glob), timeout)
+}
+
+// MustCompileMultiline compiles pattern with the m and s flags set, so that ^
+// and $ match at line boundaries and . matches \n. It panics if pattern does
+// not compile.
+func MustCompileMultiline(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?ms)` + pattern)
+}
+
+// ExpectFlags is ExpectPattern with the regexp flags in flags, any of "imsU",
+// applied to pattern, e.g. ExpectFlags(`^error:.*$`, "m", timeout).
+func (s *SubProcess) ExpectFlags(pattern, flags string, timeout time.Duration) (bool, error) {
+	for _, f := range flags {
+		if !strings.ContainsRune("imsU", f) {
+			return false, errors.Errorf("invalid regexp flag %q in %q", f, flags)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return s.ExpectPattern(pattern, timeout)
+}
@@ -0,0 +1,29 @@
+package subprocess_test
+
+import "testing"
+
+func TestExpectGlob(t *testing.T) {
+	for _, tc := range []struct {
+		glob, output string
+		matched      bool
+		rest         string
+	}{
+		{"*done*", "building\r\nall done.\r\n$ ", true, "$ "},
+		{"*done*", "building\r\nall done.\n$ ", true, "$ "},
+		{"done", "not done\r\n", false, "not done\r\n"},
+		{"d?ne", "dine\r\nde\r\n", true, "de\r\n"},
+		{"[x]", "[x]\r\n", true, ""},
+		// the line has not ended yet, so more of it may still be coming
+		{"*done", "all done", false, "all done"},
+		{"*done", "all done, but", false, "all done, but"},
+	} {
+		s := seeded(t, tc.output)
+		ok, _ := s.ExpectGlob(tc.glob, short)
+		if ok != tc.matched {
+			t.Errorf("ExpectGlob(%q) on %q matched %v, want %v", tc.glob, tc.output, ok, tc.matched)
+		}
+		if got := string(s.Buffer()); got != tc.rest {
+			t.Errorf("ExpectGlob(%q) on %q left %q, want %q", tc.glob, tc.output, got, tc.rest)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package subprocess
+
+import (
+	"bytes"
+	"regexp"
+	"time"
+)
+
+// DefaultPrompt matches the end of a typical shell prompt at the end of the
+// buffered output.
+var DefaultPrompt = regexp.MustCompile(`[$#>] $`)
+
+// SetPrompt replaces the expression used by ExpectPrompt.
+func (s *SubProcess) SetPrompt(expression *regexp.Regexp) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.prompt = expression
+}
+
+// SetIgnorePrompt makes Expect, ExpectWithTimeout, ExpectExpressions and
+// TryExpect pass over every line of output that expression matches, such as a
+// prompt that redraws itself, so that other patterns neither match inside it
+// nor across it. The line the cursor is on counts as a line too. ExpectPrompt
+// still sees ignored lines, since they are what it waits for. Nil, the
+// default, ignores nothing.
+func (s *SubProcess) SetIgnorePrompt(expression *regexp.Regexp) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.ignorePrompt = expression
+}
+
+// unignoredSpans returns the start and end of each run of lines in b that
+// ignore does not match. A line is matched without its line ending.
+func unignoredSpans(ignore *regexp.Regexp, b []byte) [][2]int {
+	var spans [][2]int
+	start := 0
+	for line := 0; line < len(b); {
+		next := bytes.IndexByte(b[line:], '\n')
+		if next < 0 {
+			next = len(b)
+		} else {
+			next += line + 1
+		}
+		if ignore.Match(bytes.TrimRight(b[line:next], "\r\n")) {
+			if line > start {
+				spans = append(spans, [2]int{start, line})
+			}
+			start = next
+		}
+		line = next
+	}
+	if start < len(b) || len(spans) == 0 {
+		spans = append(spans, [2]int{start, len(b)})
+	}
+	return spans
+}
+
+// SetPromptLookback limits ExpectPrompt to the last n bytes of the buffered
+// output, which is all a prompt anchored at the end of the output needs, so
+// that a long backlog is not scanned again every time output arrives. The
+// window always covers the end of everything buffered so far, so a prompt
+// split across reads is still found. Zero, the default, scans the whole
+// buffer. The lookback does not apply with anchored matching, which needs the
+// start of the buffer.
+func (s *SubProcess) SetPromptLookback(n int) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.promptLookback = n
+}
+
+// ExpectPrompt waits for the child to show its prompt, i.e. to be ready for
+// the next line of input.
+func (s *SubProcess) ExpectPrompt(timeout time.Duration) error {
+	s.bufLock.Lock()
+	prompt := s.prompt
+	lookback := s.promptLookback
+	s.bufLock.Unlock()
+
+	if lookback <= 0 {
+		_, err := s.expectExpressions([]*regexp.Regexp{prompt}, timeout, false)
+		return err
+	}
+
+	started := time.Now()
+	err := s.waitFor(timeout, func(b []byte) int {
+		if s.anchored {
+			if loc := s.findLocked(prompt, b); loc != nil {
+				return loc[1]
+			}
+			return -1
+		}
+
+		start := 0
+		if len(b) > lookback {
+			start = len(b) - lookback
+		}
+		if loc := prompt.FindIndex(b[start:]); loc != nil {
+			return start + loc[1]
+		}
+		return -1
+	})
+	if err == ErrTimeout {
+		err = timeoutError([]*regexp.Regexp{prompt}, started)
+	}
+	return err
+}
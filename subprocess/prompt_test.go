@@ -0,0 +1,47 @@
+package subprocess_test
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestIgnorePrompt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		output  string
+		ignore  string
+		pattern string
+		matched bool
+		rest    string
+	}{
+		{"inside an ignored line", "> ready\r\nok\r\n", `^> `, `ready`, false, "> ready\r\nok\r\n"},
+		{"after an ignored line", "> ready\r\nready\r\n", `^> `, `ready`, true, "\r\n"},
+		{"not across an ignored line", "a\r\n> x\r\nb\r\n", `^> `, `(?s)a.*b`, false, "a\r\n> x\r\nb\r\n"},
+		{"line ending left out", "> x\r\n", `^> \w+$`, `x`, false, "> x\r\n"},
+		{"bare newline", "> x\ny\n", `^> `, `x`, false, "> x\ny\n"},
+		{"the cursor line", "ok\r\n> typing", `^> `, `typing`, false, "ok\r\n> typing"},
+		{"every line", "> a\r\n> b", `^> `, `a|b`, false, "> a\r\n> b"},
+		{"nothing ignored", "> ready\r\n", ``, `ready`, true, "\r\n"},
+	} {
+		s := seeded(t, tc.output)
+		if tc.ignore != "" {
+			s.SetIgnorePrompt(regexp.MustCompile(tc.ignore))
+		}
+		ok, _ := s.ExpectWithTimeout(regexp.MustCompile(tc.pattern), short)
+		if ok != tc.matched {
+			t.Errorf("%s: %q matched %v, want %v", tc.name, tc.pattern, ok, tc.matched)
+		}
+		if got := string(s.Buffer()); got != tc.rest {
+			t.Errorf("%s: buffer is %q, want %q", tc.name, got, tc.rest)
+		}
+	}
+}
+
+func TestIgnorePromptExpectPrompt(t *testing.T) {
+	s := seeded(t, "output\r\n> ")
+	s.SetPrompt(regexp.MustCompile(`> $`))
+	s.SetIgnorePrompt(regexp.MustCompile(`^> `))
+	if err := s.ExpectPrompt(short); err != nil {
+		t.Errorf("ExpectPrompt did not see an ignored prompt: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package subprocess
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Directions of a transcript Record.
+const (
+	// Output is data read from the child.
+	Output = "out"
+	// Input is data written to the child.
+	Input = "in"
+)
+
+// Record is one entry of a structured transcript: a chunk of data that went
+// to or came from the child, and when. Transcripts hold one record per line,
+// encoded as JSON with Data in base64, so that any bytes survive the round
+// trip.
+type Record struct {
+	Direction string    `json:"dir"`
+	Time      time.Time `json:"time"`
+	Data      []byte    `json:"data"`
+	// Name is the name from SetName, if any.
+	Name string `json:"name,omitempty"`
+}
+
+// SetStructuredTranscript records the session to w as JSON lines of Record,
+// both directions interleaved in the order they happened, for ParseTranscript
+// to read back. SetTranscript remains the human readable alternative.
+func (s *SubProcess) SetStructuredTranscript(w io.Writer) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if w == nil {
+		s.records = nil
+		return
+	}
+	s.records = &recordWriter{enc: json.NewEncoder(w), name: s.name}
+}
+
+// ParseTranscript reads back a transcript written by SetStructuredTranscript.
+// Records may be of any size, since a single Send is recorded whole.
+func ParseTranscript(r io.Reader) ([]Record, error) {
+	var records []Record
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var record Record
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, errors.Wrapf(err, "error parsing transcript record %d", n)
+		}
+		records = append(records, record)
+	}
+}
+
+// recordWriter serializes records from the reader and writers of a session.
+type recordWriter struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	name string
+}
+
+func (w *recordWriter) setName(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.name = name
+}
+
+func (w *recordWriter) record(direction string, data []byte) {
+	if w == nil || len(data) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(Record{Direction: direction, Time: time.Now(), Data: data, Name: w.name})
+}
@@ -0,0 +1,64 @@
+package subprocess_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"expect/subprocess"
+)
+
+func TestStructuredTranscriptRoundTrip(t *testing.T) {
+	big := strings.Repeat("x", 2<<20)
+	tests := []struct {
+		name  string
+		sends []string
+	}{
+		{"small", []string{"one", "two"}},
+		{"binary", []string{"\x00\xff\x1b[0m"}},
+		{"larger than a scanner line", []string{big, "after"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := subprocess.NewEchoSubProcess(nil)
+			var structured bytes.Buffer
+			s.SetStructuredTranscript(&structured)
+			for _, send := range tt.sends {
+				if err := s.Send(send); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = s.Close()
+			_ = s.Wait()
+
+			records, err := subprocess.ParseTranscript(&structured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var inputs []string
+			for _, r := range records {
+				if r.Direction == subprocess.Input {
+					inputs = append(inputs, string(r.Data))
+				}
+			}
+			if len(inputs) != len(tt.sends) {
+				t.Fatalf("%d input records, want %d", len(inputs), len(tt.sends))
+			}
+			for i := range inputs {
+				if inputs[i] != tt.sends[i] {
+					t.Errorf("input %d is %d bytes, want %d", i, len(inputs[i]), len(tt.sends[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestParseTranscriptMalformed(t *testing.T) {
+	records, err := subprocess.ParseTranscript(strings.NewReader(`{"dir":"in","data":"aGk="}` + "\n{oops\n"))
+	if err == nil {
+		t.Fatal("malformed transcript parsed")
+	}
+	if len(records) != 1 || string(records[0].Data) != "hi" {
+		t.Errorf("records before the error are %+v", records)
+	}
+}
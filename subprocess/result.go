@@ -0,0 +1,71 @@
+package subprocess
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ExpectResult is the outcome of an Expect call.
+type ExpectResult int
+
+const (
+	// NoResult means no Expect has finished yet.
+	NoResult ExpectResult = iota
+	// Matched means the expected output appeared.
+	Matched
+	// TimedOut means the call gave up with ErrTimeout or ErrIdleTimeout.
+	TimedOut
+	// Errored means the call failed for any other reason, such as ErrClosed
+	// or a cancelled context.
+	Errored
+)
+
+func (r ExpectResult) String() string {
+	switch r {
+	case Matched:
+		return "matched"
+	case TimedOut:
+		return "timed out"
+	case Errored:
+		return "errored"
+	default:
+		return "none"
+	}
+}
+
+// LastExpectResult returns the outcome of the most recent Expect call to
+// finish, which agrees with the error that call returned.
+func (s *SubProcess) LastExpectResult() ExpectResult {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	return s.lastResult
+}
+
+func (s *SubProcess) recordResult(err error) {
+	result := resultOf(err)
+
+	s.bufLock.Lock()
+	s.lastResult = result
+	s.bufLock.Unlock()
+
+	if result == TimedOut {
+		s.event(slog.LevelWarn, "expect timed out", slog.String("error", err.Error()))
+	}
+}
+
+// resultOf returns the ExpectResult that goes with err.
+func resultOf(err error) ExpectResult {
+	switch err {
+	case nil:
+		return Matched
+	case ErrTimeout, ErrIdleTimeout:
+		return TimedOut
+	case context.DeadlineExceeded:
+		// a deadline on the caller's context is a timeout all the same
+		return TimedOut
+	}
+	if _, ok := err.(*TimeoutError); ok {
+		return TimedOut
+	}
+	return Errored
+}
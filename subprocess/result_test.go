@@ -0,0 +1,75 @@
+package subprocess_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func checkResult(t *testing.T, s *subprocess.SubProcess, call string, want subprocess.ExpectResult) {
+	t.Helper()
+	if got := s.LastExpectResult(); got != want {
+		t.Errorf("after %s LastExpectResult is %s, want %s", call, got, want)
+	}
+}
+
+func TestLastExpectResult(t *testing.T) {
+	s := subprocess.NewEchoSubProcess(nil)
+	defer s.Close()
+
+	if err := s.Send("one two"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ExpectPeek(regexp.MustCompile(`two`), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := s.TryExpect(regexp.MustCompile(`three`)); ok || err != nil {
+		t.Fatalf("TryExpect found a match that is not there: %v, %v", ok, err)
+	}
+	checkResult(t, s, "TryExpect without a match", subprocess.TimedOut)
+
+	if ok, err := s.TryExpect(regexp.MustCompile(`one`)); !ok || err != nil {
+		t.Fatalf("TryExpect missed a match: %v, %v", ok, err)
+	}
+	checkResult(t, s, "TryExpect with a match", subprocess.Matched)
+
+	failed := errors.New("action failed")
+	err := s.ExpectThen(regexp.MustCompile(`two`), time.Second, func([][]byte) error { return failed })
+	if err != failed {
+		t.Fatalf("ExpectThen returned %v, want %v", err, failed)
+	}
+	checkResult(t, s, "ExpectThen with a failing action", subprocess.Errored)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, s, "ReadAll", subprocess.Matched)
+}
+
+func TestLastExpectResultExit(t *testing.T) {
+	s, err := subprocess.NewSubProcess("sh", "-c", "exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.ExpectExit(4, 5*time.Second); err == nil {
+		t.Fatal("ExpectExit(4) succeeded for exit 3")
+	}
+	checkResult(t, s, "ExpectExit with the wrong code", subprocess.Errored)
+
+	if err := s.ExpectExit(3, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, s, "ExpectExit", subprocess.Matched)
+}
@@ -0,0 +1,99 @@
+package subprocess
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SetRotatingTranscript records the transcript to files in dir named after
+// prefix and the time each file was opened, starting a new file whenever the
+// current one would grow past maxBytes. The last file is closed once the
+// child's output ends, or once another transcript replaces this one.
+func (s *SubProcess) SetRotatingTranscript(dir, prefix string, maxBytes int64) error {
+	if maxBytes <= 0 {
+		return errors.Errorf("invalid transcript size limit %d", maxBytes)
+	}
+	w := &rotatingWriter{dir: dir, prefix: prefix, maxBytes: maxBytes}
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	s.SetTranscript(w)
+	return nil
+}
+
+// rotatingWriter is an io.Writer over a series of size-limited files.
+type rotatingWriter struct {
+	mu       sync.Mutex
+	dir      string
+	prefix   string
+	maxBytes int64
+	file     *os.File
+	size     int64
+	seq      int
+	closed   bool
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errors.Wrap(os.ErrClosed, "error writing transcript")
+	}
+
+	var written int
+	for len(p) > 0 {
+		if w.file == nil || w.size >= w.maxBytes {
+			if err := w.rotate(); err != nil {
+				return written, err
+			}
+		}
+
+		n := int64(len(p))
+		if room := w.maxBytes - w.size; n > room {
+			n = room
+		}
+		m, err := w.file.Write(p[:n])
+		written += m
+		w.size += int64(m)
+		if err != nil {
+			return written, errors.Wrap(err, "error writing transcript")
+		}
+		p = p[m:]
+	}
+	return written, nil
+}
+
+// rotate closes the current file, if any, and opens the next one.
+func (w *rotatingWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return errors.Wrap(err, "error closing transcript")
+		}
+	}
+
+	w.seq++
+	name := fmt.Sprintf("%s-%s-%04d.log", w.prefix, time.Now().Format("20060102T150405"), w.seq)
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error creating transcript")
+	}
+	w.file, w.size = f, 0
+	return nil
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,74 @@
+package subprocess_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingTranscript(t *testing.T) {
+	const maxBytes = 16
+	s := startCat(t)
+	dir := t.TempDir()
+	if err := s.SetRotatingTranscript(dir, "session", maxBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendLine("0123456789abcdefghij"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`ghij\r`), 2*time.Second); !ok {
+		t.Fatal(err)
+	}
+
+	// replacing the transcript closes the last file
+	s.SetTranscript(io.Discard)
+	if links := openFilesIn(t, dir); len(links) > 0 {
+		t.Errorf("transcript files still open after the transcript was replaced: %q", links)
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "session-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) < 2 {
+		t.Fatalf("transcript went to %d files, want it split", len(names))
+	}
+	var all bytes.Buffer
+	for i, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every file but the last is filled right up to the limit
+		if len(b) > maxBytes || (i < len(names)-1 && len(b) != maxBytes) {
+			t.Errorf("%s holds %d bytes", filepath.Base(name), len(b))
+		}
+		all.Write(b)
+	}
+	if !strings.HasPrefix(all.String(), "# command: cat\n") {
+		t.Errorf("transcript is %q", all.String())
+	}
+}
+
+// openFilesIn returns the files in dir that this process has open, as far as
+// /proc tells.
+func openFilesIn(t *testing.T, dir string) []string {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc to list open files")
+	}
+	var open []string
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			open = append(open, target)
+		}
+	}
+	return open
+}
@@ -0,0 +1,25 @@
+package subprocess
+
+import (
+	"fmt"
+	"time"
+)
+
+// RuntimeExceededError is returned by Wait, and by any Expect still waiting,
+// once the child has been killed for running longer than SetMaxRuntime
+// allows.
+type RuntimeExceededError struct {
+	Limit time.Duration
+}
+
+func (e *RuntimeExceededError) Error() string {
+	return fmt.Sprintf("process exceeded its maximum runtime of %s", e.Limit)
+}
+
+// SetMaxRuntime caps how long the child may run, counted from Start. When
+// the limit is reached the child is sent SIGTERM, then killed if it has not
+// exited within a few seconds, however busy it is. Zero, the default, means
+// no limit. It must be called before Start.
+func (s *SubProcess) SetMaxRuntime(limit time.Duration) {
+	s.maxRuntime = limit
+}
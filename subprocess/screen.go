@@ -0,0 +1,415 @@
+package subprocess
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var errNoScreen = errors.New("no screen to match, see SetScreen")
+
+// SetScreen turns on a small terminal emulator of rows by cols that follows
+// the output, for asserting on what a full screen program has drawn rather
+// than on the bytes it wrote. The pty is given the same window size, now if
+// the child is running and otherwise at Start. The emulator understands
+// cursor movement, erasing, scrolling and line wrapping, the subset of VT100
+// that curses programs rely on; attributes such as colors are ignored.
+func (s *SubProcess) SetScreen(rows, cols int) error {
+	if rows <= 0 || cols <= 0 {
+		return errors.Errorf("invalid screen size %dx%d", rows, cols)
+	}
+
+	s.bufLock.Lock()
+	s.screen = newScreen(rows, cols)
+	s.bufLock.Unlock()
+
+	if s.pty == nil {
+		return nil
+	}
+	return s.setSize(rows, cols)
+}
+
+// Screen returns a copy of the emulated screen, one slice of runes per row,
+// or nil without SetScreen. Blank cells are spaces.
+func (s *SubProcess) Screen() [][]rune {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if s.screen == nil {
+		return nil
+	}
+	return s.screen.snapshot()
+}
+
+// ExpectScreen waits up to timeout for match to accept the emulated screen,
+// which it is shown once straight away and again after every change. Nothing
+// is consumed. match runs while output is held back, so it must be quick and
+// must not call methods of the SubProcess.
+func (s *SubProcess) ExpectScreen(match func(screen [][]rune) bool, timeout time.Duration) error {
+	s.bufLock.Lock()
+	enabled := s.screen != nil
+	s.bufLock.Unlock()
+	if !enabled {
+		return errNoScreen
+	}
+
+	return s.waitFor(timeout, func([]byte) int {
+		if match(s.screen.snapshot()) {
+			return 0
+		}
+		return -1
+	})
+}
+
+// ScreenString renders screen as text, one line per row with trailing blanks
+// removed, for messages and simple substring checks.
+func ScreenString(screen [][]rune) string {
+	lines := make([]string, len(screen))
+	for i, row := range screen {
+		lines[i] = strings.TrimRight(string(row), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setSize sets the window size of the pty.
+func (s *SubProcess) setSize(rows, cols int) error {
+	conn, err := s.pty.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: uint16(rows), Col: uint16(cols)})
+	}); err != nil {
+		return err
+	}
+	return ioctlErr
+}
+
+const (
+	screenText = iota
+	screenEscape
+	screenCharset
+	screenCSI
+	screenOSC
+	screenOSCEscape
+)
+
+// screen is the state of the emulated terminal. bufLock guards it.
+type screen struct {
+	rows, cols int
+	cells      [][]rune
+	row, col   int
+	// wrap is set after writing to the last column; the next character
+	// goes to the start of the following line.
+	wrap     bool
+	savedRow int
+	savedCol int
+
+	state   int
+	params  []byte
+	partial []byte
+}
+
+func newScreen(rows, cols int) *screen {
+	t := &screen{rows: rows, cols: cols}
+	t.reset()
+	return t
+}
+
+func (t *screen) reset() {
+	t.cells = make([][]rune, t.rows)
+	for i := range t.cells {
+		t.cells[i] = blankRow(t.cols)
+	}
+	t.row, t.col, t.wrap = 0, 0, false
+	t.savedRow, t.savedCol = 0, 0
+}
+
+func blankRow(cols int) []rune {
+	row := make([]rune, cols)
+	for i := range row {
+		row[i] = ' '
+	}
+	return row
+}
+
+func (t *screen) snapshot() [][]rune {
+	cells := make([][]rune, len(t.cells))
+	for i, row := range t.cells {
+		cells[i] = append([]rune(nil), row...)
+	}
+	return cells
+}
+
+// feed interprets the next chunk of output. A character or sequence split
+// across chunks is completed by the next one.
+func (t *screen) feed(b []byte) {
+	if len(t.partial) > 0 {
+		b = append(t.partial, b...)
+		t.partial = nil
+	}
+	for len(b) > 0 {
+		if t.state != screenText || b[0] < utf8.RuneSelf {
+			t.byte(b[0])
+			b = b[1:]
+			continue
+		}
+		if !utf8.FullRune(b) {
+			t.partial = append([]byte(nil), b...)
+			return
+		}
+		r, size := utf8.DecodeRune(b)
+		t.put(r)
+		b = b[size:]
+	}
+}
+
+func (t *screen) byte(c byte) {
+	switch t.state {
+	case screenText:
+		switch c {
+		case 0x1b:
+			t.state = screenEscape
+		case '\r':
+			t.col, t.wrap = 0, false
+		case '\n', '\v', '\f':
+			t.lineFeed()
+		case '\b':
+			if t.col > 0 {
+				t.col--
+			}
+			t.wrap = false
+		case '\t':
+			t.col = (t.col/8 + 1) * 8
+			if t.col >= t.cols {
+				t.col = t.cols - 1
+			}
+		default:
+			if c >= 0x20 && c != 0x7f {
+				t.put(rune(c))
+			}
+		}
+
+	case screenEscape:
+		t.state = screenText
+		switch c {
+		case '[':
+			t.state = screenCSI
+			t.params = t.params[:0]
+		case ']':
+			t.state = screenOSC
+		case '(', ')', '*', '+', '#':
+			t.state = screenCharset
+		case '7':
+			t.savedRow, t.savedCol = t.row, t.col
+		case '8':
+			t.row, t.col, t.wrap = t.savedRow, t.savedCol, false
+		case 'D':
+			t.lineFeed()
+		case 'E':
+			t.col = 0
+			t.lineFeed()
+		case 'M':
+			t.reverseIndex()
+		case 'c':
+			t.reset()
+		}
+
+	case screenCharset:
+		t.state = screenText
+
+	case screenCSI:
+		if c >= 0x40 && c <= 0x7e {
+			t.state = screenText
+			t.csi(c)
+			return
+		}
+		t.params = append(t.params, c)
+
+	case screenOSC:
+		if c == 0x07 {
+			t.state = screenText
+		} else if c == 0x1b {
+			t.state = screenOSCEscape
+		}
+
+	case screenOSCEscape:
+		t.state = screenText
+	}
+}
+
+// put writes r at the cursor and advances it.
+func (t *screen) put(r rune) {
+	if t.rows == 0 || t.cols == 0 {
+		return
+	}
+	if t.wrap {
+		t.col = 0
+		t.lineFeed()
+	}
+	t.cells[t.row][t.col] = r
+	if t.col == t.cols-1 {
+		t.wrap = true
+	} else {
+		t.col++
+	}
+}
+
+// lineFeed moves the cursor down a row, scrolling at the bottom.
+func (t *screen) lineFeed() {
+	t.wrap = false
+	if t.row < t.rows-1 {
+		t.row++
+		return
+	}
+	copy(t.cells, t.cells[1:])
+	t.cells[t.rows-1] = blankRow(t.cols)
+}
+
+// reverseIndex moves the cursor up a row, scrolling at the top.
+func (t *screen) reverseIndex() {
+	t.wrap = false
+	if t.row > 0 {
+		t.row--
+		return
+	}
+	copy(t.cells[1:], t.cells)
+	t.cells[0] = blankRow(t.cols)
+}
+
+// maxScreenArg bounds the numbers in control sequences, which is more than
+// any screen is wide or tall, so that cursor arithmetic cannot overflow.
+const maxScreenArg = 1 << 16
+
+// csi carries out a control sequence ending in final.
+func (t *screen) csi(final byte) {
+	private := len(t.params) > 0 && t.params[0] == '?'
+	if private {
+		// modes such as cursor visibility do not change the text
+		return
+	}
+	args := strings.Split(string(t.params), ";")
+	arg := func(i, def int) int {
+		if i >= len(args) {
+			return def
+		}
+		// a sign is collected as a parameter byte like any other, so a
+		// negative count has to be turned away here
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 1 {
+			return def
+		}
+		if n > maxScreenArg {
+			n = maxScreenArg
+		}
+		return n
+	}
+
+	t.wrap = false
+	switch final {
+	case 'A':
+		t.moveTo(t.row-arg(0, 1), t.col)
+	case 'B', 'e':
+		t.moveTo(t.row+arg(0, 1), t.col)
+	case 'C', 'a':
+		t.moveTo(t.row, t.col+arg(0, 1))
+	case 'D':
+		t.moveTo(t.row, t.col-arg(0, 1))
+	case 'E':
+		t.moveTo(t.row+arg(0, 1), 0)
+	case 'F':
+		t.moveTo(t.row-arg(0, 1), 0)
+	case 'G', '`':
+		t.moveTo(t.row, arg(0, 1)-1)
+	case 'd':
+		t.moveTo(arg(0, 1)-1, t.col)
+	case 'H', 'f':
+		t.moveTo(arg(0, 1)-1, arg(1, 1)-1)
+	case 'J':
+		t.eraseDisplay(arg(0, 0))
+	case 'K':
+		t.eraseLine(arg(0, 0))
+	case 'X':
+		t.clear(t.row, t.col, t.col+arg(0, 1))
+	case 'P':
+		n := arg(0, 1)
+		line := t.cells[t.row]
+		if t.col+n > t.cols {
+			n = t.cols - t.col
+		}
+		copy(line[t.col:], line[t.col+n:])
+		t.clear(t.row, t.cols-n, t.cols)
+	case '@':
+		n := arg(0, 1)
+		line := t.cells[t.row]
+		if t.col+n > t.cols {
+			n = t.cols - t.col
+		}
+		copy(line[t.col+n:], line[t.col:])
+		t.clear(t.row, t.col, t.col+n)
+	case 's':
+		t.savedRow, t.savedCol = t.row, t.col
+	case 'u':
+		t.row, t.col = t.savedRow, t.savedCol
+	}
+}
+
+func (t *screen) moveTo(row, col int) {
+	if row < 0 {
+		row = 0
+	}
+	if row >= t.rows {
+		row = t.rows - 1
+	}
+	if col < 0 {
+		col = 0
+	}
+	if col >= t.cols {
+		col = t.cols - 1
+	}
+	t.row, t.col = row, col
+}
+
+// clear blanks the cells of row from start up to end.
+func (t *screen) clear(row, start, end int) {
+	if end > t.cols {
+		end = t.cols
+	}
+	for i := start; i < end; i++ {
+		t.cells[row][i] = ' '
+	}
+}
+
+func (t *screen) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		t.clear(t.row, t.col, t.cols)
+	case 1:
+		t.clear(t.row, 0, t.col+1)
+	case 2:
+		t.clear(t.row, 0, t.cols)
+	}
+}
+
+func (t *screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.eraseLine(0)
+		for row := t.row + 1; row < t.rows; row++ {
+			t.clear(row, 0, t.cols)
+		}
+	case 1:
+		t.eraseLine(1)
+		for row := 0; row < t.row; row++ {
+			t.clear(row, 0, t.cols)
+		}
+	case 2, 3:
+		for row := 0; row < t.rows; row++ {
+			t.clear(row, 0, t.cols)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package subprocess_test
+
+import (
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func TestScreenNegativeCounts(t *testing.T) {
+	s := subprocess.NewEchoSubProcess(nil)
+	defer s.Close()
+	if err := s.SetScreen(5, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// a negative count used to slice the row out of bounds and take the
+	// reader down with it
+	if err := s.Send("ab\x1b[-5Pc\x1b[-3@d"); err != nil {
+		t.Fatal(err)
+	}
+	err := s.ExpectScreen(func(screen [][]rune) bool {
+		return string(screen[0][:4]) == "abcd"
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("screen is %q: %v", subprocess.ScreenString(s.Screen()), err)
+	}
+}
+
+func TestSetScreenInvalidSize(t *testing.T) {
+	s := subprocess.NewEchoSubProcess(nil)
+	defer s.Close()
+	for _, size := range [][2]int{{0, 10}, {5, 0}, {-1, 10}, {5, -3}} {
+		if err := s.SetScreen(size[0], size[1]); err == nil {
+			t.Errorf("SetScreen(%d, %d) succeeded", size[0], size[1])
+		}
+	}
+}
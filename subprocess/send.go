@@ -0,0 +1,126 @@
+package subprocess
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const sendChunkSize = 32 * 1024
+
+// SendFile streams the contents of the file at path to the pty without
+// loading it into memory. It stops early if the SubProcess context is done.
+func (s *SubProcess) SendFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "error opening file to send")
+	}
+	defer f.Close()
+
+	return s.SendReader(f)
+}
+
+// SendReader copies r into the pty in chunks until r is exhausted or the
+// SubProcess context is done. Errors report how many bytes made it to the pty.
+func (s *SubProcess) SendReader(r io.Reader) error {
+	var written int64
+	chunk := make([]byte, sendChunkSize)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return errors.Wrapf(s.ctx.Err(), "stopped after sending %d bytes", written)
+		default:
+		}
+
+		n, err := r.Read(chunk)
+		if n > 0 {
+			sent, werr := s.SendBytes(chunk[:n])
+			written += int64(sent)
+			if werr != nil {
+				return errors.Wrapf(werr, "error writing to pty after sending %d bytes", written)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading input after sending %d bytes", written)
+		}
+	}
+}
+
+// CloseStdin signals end of input to the child. A pty has no write side that
+// can be closed on its own without hanging up the whole terminal, so this
+// sends the terminal's end-of-file character, normally ^D, instead. The child
+// sees end of file only if it reads in canonical mode and the character
+// arrives at the start of a line; otherwise it first completes the pending
+// line. Programs that put the terminal in raw mode just receive the byte.
+func (s *SubProcess) CloseStdin() error {
+	eof := byte(4)
+	if t, err := s.getTermios(); err == nil && t.Cc[unix.VEOF] != 0 {
+		eof = t.Cc[unix.VEOF]
+	}
+	_, err := s.SendBytes([]byte{eof})
+	return err
+}
+
+// SendExpect sends input and then waits up to timeout for expression. Output
+// that arrived before the send is discarded first, and when the pty echoes
+// input the echoed copy of input is skipped, so that neither can satisfy the
+// match.
+func (s *SubProcess) SendExpect(input string, expression *regexp.Regexp, timeout time.Duration) (bool, error) {
+	var echoed []byte
+	if echo, err := s.Echo(); err == nil && echo {
+		echoed = echoOf([]byte(input))
+	}
+
+	s.bufLock.Lock()
+	s.consumed += s.buf.Len()
+	s.buf.Reset()
+	s.bufLock.Unlock()
+
+	if err := s.Send(input); err != nil {
+		return false, err
+	}
+
+	err := s.waitFor(timeout, func(b []byte) int {
+		start := 0
+		if n := len(echoed); n > 0 {
+			if len(b) < n {
+				n = len(b)
+			}
+			if bytes.Equal(b[:n], echoed[:n]) {
+				if n < len(echoed) {
+					// the echo is still coming in
+					return -1
+				}
+				start = n
+			}
+		}
+		if loc := s.findLocked(expression, b[start:]); loc != nil {
+			return start + loc[1]
+		}
+		return -1
+	})
+	return err == nil, err
+}
+
+// echoOf returns input as the pty echoes it back: a carriage return is
+// turned into a newline on the way in, and every newline is echoed as
+// "\r\n".
+func echoOf(input []byte) []byte {
+	echoed := make([]byte, 0, len(input))
+	for _, c := range input {
+		if c == '\r' || c == '\n' {
+			echoed = append(echoed, '\r', '\n')
+			continue
+		}
+		echoed = append(echoed, c)
+	}
+	return echoed
+}
@@ -0,0 +1,31 @@
+package subprocess
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SetSlogLogger sets a structured logger for the life of the child: its
+// start and exit, Expect calls that time out, and the number of bytes read
+// and written, the latter at debug level. Nothing is logged without one.
+func (s *SubProcess) SetSlogLogger(l *slog.Logger) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.slog = l
+}
+
+// event logs msg with attrs to the structured logger, if there is one.
+// bufLock must not be held.
+func (s *SubProcess) event(level slog.Level, msg string, attrs ...slog.Attr) {
+	s.bufLock.Lock()
+	l, name := s.slog, s.name
+	s.bufLock.Unlock()
+
+	if l == nil {
+		return
+	}
+	if name != "" {
+		attrs = append([]slog.Attr{slog.String("name", name)}, attrs...)
+	}
+	l.LogAttrs(context.Background(), level, msg, attrs...)
+}
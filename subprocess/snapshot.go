@@ -0,0 +1,45 @@
+package subprocess
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotWhenIdle waits for the child to stop writing for idle and returns
+// a copy of the output buffered by then, such as the current state of a full
+// screen program. Nothing is consumed, so later Expect calls still see it. If
+// the output keeps changing for longer than max, the buffer is returned with
+// ErrTimeout. Output that has ended is as stable as it gets and is returned
+// straight away.
+func (s *SubProcess) SnapshotWhenIdle(idle, max time.Duration) ([]byte, error) {
+	idleTimer := time.NewTimer(idle)
+	defer idleTimer.Stop()
+	maxTimer := time.NewTimer(max)
+	defer maxTimer.Stop()
+
+	for {
+		s.bufLock.Lock()
+		updated, readErr, exited := s.updated, s.readErr, s.exited
+		s.bufLock.Unlock()
+
+		if isEOF(readErr) || exited {
+			return s.Buffer(), nil
+		}
+		if readErr != nil {
+			return s.Buffer(), errors.Wrap(readErr, "error reading from pty")
+		}
+
+		select {
+		case <-updated:
+			if !idleTimer.Stop() {
+				<-idleTimer.C
+			}
+			idleTimer.Reset(idle)
+		case <-idleTimer.C:
+			return s.Buffer(), nil
+		case <-maxTimer.C:
+			return s.Buffer(), ErrTimeout
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package subprocess
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// source is an extra stream of output registered with AddSource. It has its
+// own buffer so that matches never span data from different streams.
+type source struct {
+	buf     bytes.Buffer
+	readErr error
+}
+
+// AddSource registers r, for example a log file the child writes to, as an
+// extra stream of output that ExpectSource and ExpectAnySource can match
+// against. Reading starts straight away and continues until r returns an
+// error. The name must be unique and not empty; the empty name refers to the
+// pty itself. The other Expect methods only ever look at the pty.
+func (s *SubProcess) AddSource(name string, r io.Reader) error {
+	if name == "" {
+		return errors.New("source name must not be empty")
+	}
+
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if _, ok := s.sources[name]; ok {
+		return errors.Errorf("source %q already added", name)
+	}
+	if s.sources == nil {
+		s.sources = make(map[string]*source)
+	}
+	src := &source{}
+	s.sources[name] = src
+	s.sourceNames = append(s.sourceNames, name)
+
+	go s.readSource(src, r)
+	return nil
+}
+
+func (s *SubProcess) readSource(src *source, r io.Reader) {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+
+		s.bufLock.Lock()
+		_, _ = src.buf.Write(chunk[:n])
+		if err != nil {
+			src.readErr = err
+		}
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.bufLock.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ExpectSource waits for expression in the named source only, or in the pty
+// if name is empty.
+func (s *SubProcess) ExpectSource(name string, expression *regexp.Regexp, timeout time.Duration) (bool, error) {
+	if name == "" {
+		return s.ExpectWithTimeout(expression, timeout)
+	}
+
+	s.bufLock.Lock()
+	_, ok := s.sources[name]
+	s.bufLock.Unlock()
+	if !ok {
+		return false, errors.Errorf("unknown source %q", name)
+	}
+
+	_, err := s.expectSources([]string{name}, expression, timeout)
+	return err == nil, err
+}
+
+// ExpectAnySource waits for expression in the pty or any added source, each
+// matched separately, and returns the name of the one it appeared in ("" for
+// the pty).
+func (s *SubProcess) ExpectAnySource(expression *regexp.Regexp, timeout time.Duration) (string, error) {
+	s.bufLock.Lock()
+	names := append([]string{""}, s.sourceNames...)
+	s.bufLock.Unlock()
+
+	return s.expectSources(names, expression, timeout)
+}
+
+// expectSources is waitFor across several streams. It fails with ErrClosed
+// once every stream has ended without a match.
+func (s *SubProcess) expectSources(names []string, expression *regexp.Regexp, timeout time.Duration) (name string, err error) {
+	defer func() {
+		s.recordResult(err)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.bufLock.Lock()
+		ended := 0
+		for _, name := range names {
+			if name == "" {
+				if loc := s.findLocked(expression, s.buf.Bytes()); loc != nil {
+					s.matchedLocked(loc[1])
+					s.bufLock.Unlock()
+					return name, nil
+				}
+				if s.readErr != nil {
+					ended++
+				}
+				continue
+			}
+
+			src := s.sources[name]
+			if loc := s.findLocked(expression, src.buf.Bytes()); loc != nil {
+				if s.consumeOnMatch {
+					src.buf.Next(loc[1])
+				}
+				s.bufLock.Unlock()
+				return name, nil
+			}
+			if src.readErr != nil {
+				ended++
+			}
+		}
+		updated := s.updated
+		s.bufLock.Unlock()
+
+		if ended == len(names) {
+			return "", ErrClosed
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return "", ErrTimeout
+		}
+	}
+}
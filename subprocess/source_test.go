@@ -0,0 +1,97 @@
+package subprocess_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"expect/subprocess"
+)
+
+// withSources returns an unstarted SubProcess whose pty output is pty, with
+// the named sources added, each ending after its contents.
+func withSources(t *testing.T, pty string, sources map[string]string) *subprocess.SubProcess {
+	t.Helper()
+	s := seeded(t, pty)
+	for name, output := range sources {
+		if err := s.AddSource(name, strings.NewReader(output)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestExpectSource(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pty     string
+		sources map[string]string
+		source  string
+		pattern string
+		matched bool
+	}{
+		{"in the source", "$ ", map[string]string{"log": "started\n"}, "log", `started`, true},
+		{"in the pty", "$ ", map[string]string{"log": "started\n"}, "", `\$ `, true},
+		{"only in the pty", "started", map[string]string{"log": "nothing\n"}, "log", `started`, false},
+		{"only in the source", "$ ", map[string]string{"log": "started\n"}, "", `started`, false},
+		{"not across sources", "foo", map[string]string{"log": "bar"}, "log", `foobar`, false},
+		{"not across other sources", "", map[string]string{"a": "foo", "b": "bar"}, "b", `foobar`, false},
+	} {
+		s := withSources(t, tc.pty, tc.sources)
+		ok, err := s.ExpectSource(tc.source, regexp.MustCompile(tc.pattern), short)
+		if ok != tc.matched {
+			t.Errorf("%s: ExpectSource matched %v, %v", tc.name, ok, err)
+		}
+	}
+}
+
+func TestExpectAnySource(t *testing.T) {
+	for _, tc := range []struct {
+		pty     string
+		sources map[string]string
+		pattern string
+		want    string
+		fails   bool
+	}{
+		{"$ ", map[string]string{"log": "started\n"}, `started`, "log", false},
+		{"started", map[string]string{"log": "started\n"}, `started`, "", false},
+		{"", map[string]string{"a": "one\n", "b": "two\n"}, `two`, "b", false},
+		{"foo", map[string]string{"log": "bar"}, `foobar`, "", true},
+	} {
+		s := withSources(t, tc.pty, tc.sources)
+		got, err := s.ExpectAnySource(regexp.MustCompile(tc.pattern), short)
+		if (err != nil) != tc.fails || got != tc.want {
+			t.Errorf("ExpectAnySource(%q) returned %q, %v, want %q", tc.pattern, got, err, tc.want)
+		}
+	}
+}
+
+func TestSourceBuffersAreSeparate(t *testing.T) {
+	s := withSources(t, "", map[string]string{"a": "ready ready\n", "b": "ready\n"})
+	ready := regexp.MustCompile(`ready`)
+	// each match consumes from its own source only
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := s.ExpectSource("a", ready, short); ok != want {
+			t.Errorf("match %d in a is %v, want %v", i, ok, want)
+		}
+	}
+	if ok, err := s.ExpectSource("b", ready, short); !ok {
+		t.Errorf("b lost its output to a: %v", err)
+	}
+}
+
+func TestAddSourceNames(t *testing.T) {
+	s := seeded(t, "")
+	if err := s.AddSource("", strings.NewReader("")); err == nil {
+		t.Error("AddSource took an empty name")
+	}
+	if err := s.AddSource("log", strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddSource("log", strings.NewReader("")); err == nil {
+		t.Error("AddSource took the same name twice")
+	}
+	if _, err := s.ExpectSource("other", regexp.MustCompile(`x`), short); err == nil {
+		t.Error("ExpectSource accepted an unknown source")
+	}
+}
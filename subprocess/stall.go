@@ -0,0 +1,81 @@
+package subprocess
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SetSendStallThreshold makes a write to the child that is still blocked
+// after threshold log a warning, to both the debug and the structured logger,
+// naming the child as possibly stuck: a pty only takes so much input before
+// the child has to read it. The write itself carries on; SendContext bounds
+// it. Zero, the default, turns the warning off.
+func (s *SubProcess) SetSendStallThreshold(threshold time.Duration) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.stallThreshold = threshold
+}
+
+// watchForStall starts the stall warning for a write of size bytes and
+// returns a func that stops it.
+func (s *SubProcess) watchForStall(threshold time.Duration, size int) func() bool {
+	started := time.Now()
+	timer := time.AfterFunc(threshold, func() {
+		s.bufLock.Lock()
+		debug := s.debug
+		s.bufLock.Unlock()
+
+		command := s.CommandLine()
+		if debug != nil {
+			debug.Printf("send: writing %d bytes to %q has been blocked for %s, the child may not be reading its input",
+				size, command, time.Since(started).Round(time.Millisecond))
+		}
+		s.event(slog.LevelWarn, "send stalled",
+			slog.String("command", command),
+			slog.Int("bytes", size),
+			slog.Duration("blocked", time.Since(started)))
+	})
+	return timer.Stop
+}
+
+// SendContext is Send that gives up with ctx.Err() once ctx is done, even if
+// the child has stopped reading and the write is blocked. The part of value
+// written by then has been sent. It also gives up while waiting for another
+// write to finish, and never cuts that other write short.
+func (s *SubProcess) SendContext(ctx context.Context, value string) error {
+	_, err := s.write(ctx, []byte(value))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// armWriteDeadline interrupts a blocked write to the pty once ctx is done
+// and returns a func that disarms it again. writeLock must be held from
+// before arming until after disarming.
+func (s *SubProcess) armWriteDeadline(ctx context.Context) func() {
+	type deadliner interface {
+		SetWriteDeadline(t time.Time) error
+	}
+	d, ok := s.conn.(deadliner)
+	if !ok {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = d.SetWriteDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		_ = d.SetWriteDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,66 @@
+package subprocess_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+// startSlowReader starts a child that reads nothing for delay and then
+// everything, with the pty in raw mode so that big writes block on it.
+func startSlowReader(t *testing.T, delay string) *subprocess.SubProcess {
+	t.Helper()
+	s, err := subprocess.NewSubProcess("sh", "-c", "stty raw -echo; sleep "+delay+"; cat >/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	// let stty take effect before anything is written
+	time.Sleep(200 * time.Millisecond)
+	return s
+}
+
+func TestSendContextInterruptsBlockedWrite(t *testing.T) {
+	s := startSlowReader(t, "30")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if err := s.SendContext(ctx, strings.Repeat("x", 1<<20)); err != context.DeadlineExceeded {
+		t.Fatalf("SendContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("SendContext took %s to give up", elapsed)
+	}
+}
+
+func TestSendContextLeavesOtherWritesAlone(t *testing.T) {
+	s := startSlowReader(t, "1")
+
+	sent := make(chan error, 1)
+	go func() { sent <- s.Send(strings.Repeat("x", 1<<20)) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// this one times out waiting behind the blocked Send, which must not be
+	// cut short by its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.SendContext(ctx, "y"); err != context.DeadlineExceeded {
+		t.Errorf("SendContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Errorf("Send failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Send did not finish once the child read its input")
+	}
+}
@@ -0,0 +1,52 @@
+package subprocess_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func TestStartWithTimeoutLateFailure(t *testing.T) {
+	slowFailure := subprocess.WithPtyFunc(func(*exec.Cmd) (*os.File, error) {
+		time.Sleep(100 * time.Millisecond)
+		return nil, errors.New("no pty for you")
+	})
+	s, err := subprocess.NewSubProcessWithOptions("true", nil, slowFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartWithTimeout(10 * time.Millisecond); err != subprocess.ErrStartTimeout {
+		t.Fatalf("StartWithTimeout returned %v, want %v", err, subprocess.ErrStartTimeout)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- s.Wait() }()
+	select {
+	case err := <-waited:
+		if err != subprocess.ErrStartTimeout {
+			t.Errorf("Wait returned %v, want %v", err, subprocess.ErrStartTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait blocked after the abandoned start failed")
+	}
+}
+
+func TestSendBeforeStart(t *testing.T) {
+	s, err := subprocess.NewSubProcess("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send("too early"); err == nil {
+		t.Error("Send succeeded before Start")
+	}
+	if err := s.SendLine("too early"); err == nil {
+		t.Error("SendLine succeeded before Start")
+	}
+	if _, err := s.SendBytes([]byte("too early")); err == nil {
+		t.Error("SendBytes succeeded before Start")
+	}
+}
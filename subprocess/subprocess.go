@@ -3,11 +3,11 @@ package subprocess
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
-	"os/signal"
 	"regexp"
 	"sync"
 	"syscall"
@@ -20,111 +20,724 @@ import (
 
 var ErrTimeout = errors.New("timeout expecting results")
 
+// TimeoutError is returned by Expect, ExpectWithTimeout, ExpectExpressions,
+// ExpectExpressionsWithTimeout, ExpectFull, ExpectAtEOF, ExpectPrompt,
+// ExpectPattern and ExpectGlob when the expected output did not appear in
+// time. It matches ErrTimeout under errors.Is, which
+// the other methods return as it is, so errors.Is(err, ErrTimeout) covers
+// them all.
+type TimeoutError struct {
+	// Patterns are the expressions that were awaited.
+	Patterns []string
+	Elapsed  time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s after %s waiting for %q", ErrTimeout, e.Elapsed.Round(time.Millisecond), e.Patterns)
+}
+
+// Is reports whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// ErrIdleTimeout is returned when the child stays silent for longer than
+// allowed, as opposed to taking too long overall.
+var ErrIdleTimeout = errors.New("timeout waiting for output")
+
+// ErrClosed is returned when the child has gone away, and with it the other
+// side of the pty, before the expected output appeared.
+var ErrClosed = errors.New("subprocess closed")
+
+// ErrStartTimeout is returned by StartWithTimeout when the child could not be
+// spawned in time.
+var ErrStartTimeout = errors.New("timeout starting process")
+
 const DefaultTimeout = 30 * time.Second
 
+// defaultTimeout is used by Expect and ExpectExpressions when no per-instance
+// timeout is set. It can be overridden for the whole process through the
+// SUBPROCESS_DEFAULT_TIMEOUT environment variable, e.g. "2m" on slow machines.
+var defaultTimeout = DefaultTimeout
+
+func init() {
+	if d, err := time.ParseDuration(os.Getenv("SUBPROCESS_DEFAULT_TIMEOUT")); err == nil && d > 0 {
+		defaultTimeout = d
+	}
+}
+
+// SubProcess drives a command attached to a pseudo-terminal.
+//
+// Reads and writes on the pty are independent: a single reader goroutine,
+// started by Start, owns the read side and appends everything it sees to an
+// internal buffer, while writes are serialized by their own lock. It is
+// therefore safe to Send from one goroutine while another is blocked in
+// Expect.
+//
+// Once Start has returned, every method may be called from any goroutine.
+// Concurrent Expect calls are each consistent, since matching and consuming
+// happen under the buffer lock, but they compete for the same output: each
+// occurrence goes to whichever call matches it first. The methods that say
+// so must be called before Start, and Start itself from a single goroutine.
 type SubProcess struct {
-	command  *exec.Cmd
-	ctx      context.Context
-	pty      *os.File
-	log      *logger
-	oldState *terminal.State
+	command *exec.Cmd
+	ctx     context.Context
+	pty     *os.File
+	// conn carries the data: the pty, or a stand-in without a real process
+	// such as the one from NewEchoSubProcess.
+	conn io.ReadWriteCloser
+	log  *logger
+
+	startPty func(*exec.Cmd) (*os.File, error)
+	setsid   bool
+
+	// writeLock serializes writes; it is a channel so that SendContext can
+	// give up waiting for it.
+	writeLock chan struct{}
+
+	done    chan struct{}
+	waitErr error
+	// abandoned is set when StartWithTimeout gave up on the child, which is
+	// then killed and reaped in the background.
+	abandoned bool
+
+	// readDone is closed when readOutput returns.
+	readDone chan struct{}
+
+	exitEndsOutput bool
+	maxRuntime     time.Duration
+	// pipes holds our ends of AddExtraPipe pipes by the child's fd.
+	pipes map[int]*os.File
+
+	// bufLock guards the fields below, which are filled in by readOutput or
+	// set while other goroutines may be using the SubProcess.
+	bufLock sync.Mutex
+	// oldState is the state of the terminal on oldStateFd that Interact put
+	// in raw mode, for Close to restore.
+	oldState   *terminal.State
+	oldStateFd int
+	killSignal os.Signal
+	localRaw   bool
+	buf        bytes.Buffer
+	// consumed counts the bytes that have left buf, so that consumed plus
+	// an index into buf is an offset into everything the child has written.
+	consumed       int
+	lastMatchEnd   int
+	consumeOnMatch bool
+	anchored       bool
+	timeout        time.Duration
+	readErr        error
+	updated        chan struct{}
+	forward        io.Writer
+	transcript     io.Writer
+	// transcriptOut is transcript with the name prefix added.
+	transcriptOut io.Writer
+	tap           io.Writer
+	onLine        func(line string)
+	// partialLine holds output after the last newline for onLine; only
+	// readOutput touches it.
+	partialLine []byte
+	debug       Logger
+	sources     map[string]*source
+	onReadError func(error) bool
+	watches     []watch
+	startedAt   time.Time
+	firstByteAt time.Time
+	exited      bool
+	sourceNames []string
+	prompt      *regexp.Regexp
+	// promptLookback bounds how much of buf ExpectPrompt scans.
+	promptLookback int
+	lastResult     ExpectResult
+	suppressEcho   bool
+	// pendingEcho is written input whose echo has not come back yet.
+	pendingEcho []byte
+	output      chan []byte
+	outputEnded bool
+	// runtimeErr is set once the child is killed by SetMaxRuntime.
+	runtimeErr   error
+	stripANSI    bool
+	stripANSISet bool
+	historySize  int
+	history      []byte
+	slog         *slog.Logger
+	// stallThreshold is how long a write may block before it is reported.
+	stallThreshold time.Duration
+	records        *recordWriter
+	subscribers    []*subscriber
+	logInput       bool
+	redactions     []*regexp.Regexp
+	ignorePrompt   *regexp.Regexp
+	onInteractExit func(err error)
+	deadline       time.Time
+	audits         []*audit
+	exitCodeMapper func(*os.ProcessState) int
+	capture        *bytes.Buffer
+	screen         *screen
+	name           string
+	maxBuffer      int
+	onTrim         func(droppedBytes int)
+	// captured, transcribed and recorded count what has gone to capture,
+	// transcriptOut and records, for SetMaxCapture.
+	maxCapture       int64
+	captured         int64
+	transcribed      int64
+	recorded         int64
+	captureTruncated bool
+	// debugBase is the logger from SetDebugLogger, and debug the same with
+	// the name prefix added.
+	debugBase Logger
+	// ansi carries escape sequences split across reads; only readOutput
+	// touches it.
+	ansi ansiStripper
 }
 
+// exitDrainPeriod is how long WithSigchldDetection waits after the child
+// exits for its last output to be read.
+const exitDrainPeriod = 50 * time.Millisecond
+
+// killGracePeriod is how long a child has to exit after SIGTERM before it is
+// killed outright.
+const killGracePeriod = 3 * time.Second
+
 func NewSubProcess(command string, args ...string) (*SubProcess, error) {
-	ctx := context.Background()
-	cmd := exec.CommandContext(ctx, command, args...)
+	return NewSubProcessContext(context.Background(), command, args...)
+}
+
+// NewSubProcessContext is like NewSubProcess but ties the lifetime of the child
+// to ctx: once ctx is done, Wait terminates the child and returns ctx.Err().
+func NewSubProcessContext(ctx context.Context, command string, args ...string) (*SubProcess, error) {
+	if command == "" {
+		return nil, errors.New("command must not be empty")
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, notFound(command, err)
+	}
+
+	// ctx is handled by Wait rather than exec.CommandContext, which would
+	// SIGKILL the child without giving it a chance to clean up.
+	return newSubProcess(ctx, exec.Command(command, args...)), nil
+}
+
+// NewSubProcessPath is like NewSubProcess but runs the executable at path
+// exactly as given. NewSubProcess searches PATH for a command without a
+// slash, so a binary of the same name earlier in PATH wins; here there is no
+// search, and a relative path is taken relative to the working directory.
+func NewSubProcessPath(path string, args ...string) (*SubProcess, error) {
+	if path == "" {
+		return nil, errors.New("path must not be empty")
+	}
+
+	cmd := &exec.Cmd{
+		Path: path,
+		Args: append([]string{path}, args...),
+	}
+	return newSubProcess(context.Background(), cmd), nil
+}
 
+func newSubProcess(ctx context.Context, cmd *exec.Cmd) *SubProcess {
 	return &SubProcess{
 		command: cmd,
 		log:     &logger{},
 		ctx:     ctx,
-	}, nil
+		updated: make(chan struct{}),
+		done:    make(chan struct{}),
+
+		readDone:  make(chan struct{}),
+		writeLock: make(chan struct{}, 1),
+		prompt:    DefaultPrompt,
+
+		killSignal:     os.Kill,
+		setsid:         true,
+		localRaw:       true,
+		consumeOnMatch: true,
+	}
 }
 
-func (s *SubProcess) listenForShutdown(signals chan os.Signal, errs chan error, stop chan struct{}) {
-	for {
-		select {
-		case e := <-errs:
-			log.Printf("failed with error: %v", e)
-			stop <- struct{}{}
-			return
+func (s *SubProcess) LogOutput() string {
+	return s.log.String()
+}
 
-		case sig := <-signals:
-			switch sig {
-			case syscall.SIGWINCH:
-				if err := pty.InheritSize(os.Stdin, s.pty); err != nil {
-					// probably not worth shutting down the process over this error, so let's log and move on
-					log.Printf("error resizing pty: %s", err)
-				}
+// Buffer returns a copy of the output that has been read from the pty and is
+// still available to Expect.
+func (s *SubProcess) Buffer() []byte {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	return append([]byte(nil), s.buf.Bytes()...)
+}
 
-			default:
-				stop <- struct{}{}
-				return
-			}
+// TimeToFirstByte returns how long after Start the child's first output was
+// read, and false if nothing has been read yet.
+func (s *SubProcess) TimeToFirstByte() (time.Duration, bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if s.firstByteAt.IsZero() {
+		return 0, false
+	}
+	return s.firstByteAt.Sub(s.startedAt), true
+}
+
+// Reset discards all buffered output, including that of added sources, and
+// forgets the last match, while leaving the child running. LastMatchEnd
+// reports 0 until the next match; offsets after that still count from the
+// start of the session.
+func (s *SubProcess) Reset() {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	s.consumed += s.buf.Len()
+	s.buf.Reset()
+	s.lastMatchEnd = 0
+	s.history = nil
+	for _, src := range s.sources {
+		src.buf.Reset()
+	}
+}
+
+// SetConsumeOnMatch controls whether a successful Expect discards the match
+// and everything before it, which is the default. With consumption turned off
+// every later Expect scans, and matches against, all output since Start, and
+// the buffer grows for as long as the child keeps writing.
+func (s *SubProcess) SetConsumeOnMatch(consume bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.consumeOnMatch = consume
+}
+
+// SetAnchoredMatching makes matches count only if they start at the first
+// unconsumed byte, so that a pattern behaves as if it began with \A and ^ means
+// "start of unconsumed data" rather than "anywhere a line starts". Output
+// arrives in chunks, so an anchored pattern keeps waiting while the data it
+// needs is incomplete, but can no longer match once the unconsumed data starts
+// with something else, for example the rest of a line that a previous Expect
+// matched only part of.
+func (s *SubProcess) SetAnchoredMatching(anchored bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.anchored = anchored
+}
+
+// LastMatchEnd returns the offset, counted from the first byte the child
+// wrote, just past the end of the most recent successful match. It never
+// decreases during the life of the SubProcess, except through Reset. As long
+// as nothing has been consumed it is also an index into Buffer.
+func (s *SubProcess) LastMatchEnd() int {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	return s.lastMatchEnd
+}
+
+func (s *SubProcess) Start() error {
+	if s.command == nil {
+		// nothing to start, the stand-in runs from construction
+		return nil
+	}
+
+	p, err := s.spawn()
+	if err != nil {
+		return err
+	}
+	s.started(p)
+	return nil
+}
+
+// StartWithTimeout is Start, giving up with ErrStartTimeout if the child has not
+// been spawned within timeout. A child that turns up after that is killed
+// and its pty closed.
+func (s *SubProcess) StartWithTimeout(timeout time.Duration) error {
+	if s.command == nil {
+		return nil
+	}
+
+	type spawned struct {
+		pty *os.File
+		err error
+	}
+	result := make(chan spawned, 1)
+	go func() {
+		p, err := s.spawn()
+		result <- spawned{p, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-result:
+		if r.err != nil {
+			return r.err
 		}
+		s.started(r.pty)
+		return nil
+
+	case <-timer.C:
+		go func() {
+			r := <-result
+			if r.err == nil {
+				_ = r.pty.Close()
+				_ = s.command.Process.Kill()
+				_ = s.command.Wait()
+			}
+			s.waitErr = ErrStartTimeout
+			close(s.done)
+		}()
+		s.abandoned = true
+		return ErrStartTimeout
 	}
 }
 
-func waitForCommandCompletion(cmd *exec.Cmd, errs chan error, stop chan struct{}) {
-	err := cmd.Wait()
+// spawn starts the child on a new pty and returns the pty.
+func (s *SubProcess) spawn() (*os.File, error) {
+	start := s.startPty
+	if start == nil {
+		start = s.startWithPty
+	}
+	p, err := start(s.command)
 	if err != nil {
-		errs <- err
+		return nil, notFound(s.command.Path, err)
 	}
-	stop <- struct{}{}
+	return p, nil
 }
 
-func (s *SubProcess) Interact() {
-	errs := make(chan error)
-	stop := make(chan struct{}, 1)
+// started takes over the pty of a freshly spawned child.
+func (s *SubProcess) started(p *os.File) {
+	s.pty = pollable(p)
+	s.conn = s.pty
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGINT, syscall.SIGWINCH, syscall.SIGTSTP)
+	s.bufLock.Lock()
+	s.startedAt = time.Now()
+	if !s.stripANSISet {
+		s.stripANSI = s.dumbTerminal()
+	}
+	if s.screen != nil {
+		_ = s.setSize(s.screen.rows, s.screen.cols)
+	}
+	transcript := s.transcriptOut
+	s.bufLock.Unlock()
+	if transcript != nil {
+		s.writeTranscriptHeader(transcript)
+	}
 
-	_, cancel := context.WithCancel(s.ctx)
+	s.closeExtraReaders()
 
-	go s.listenForShutdown(signals, errs, stop)
-	go waitForCommandCompletion(s.command, errs, stop)
-	go io.Copy(os.Stdout, s.pty)
-	go io.Copy(s.pty, os.Stdin)
+	s.event(slog.LevelInfo, "started",
+		slog.String("command", s.CommandLine()),
+		slog.Int("pid", s.command.Process.Pid))
 
-	<-stop
-	cancel()
-	_ = s.pty.Close()
+	go s.reap()
+	go s.readOutput()
 }
 
-func (s *SubProcess) LogOutput() string {
-	return s.log.String()
+// pollable returns a non-blocking version of f, so that reads from it are
+// interrupted by Close. Opening a pty calls Fd, which puts the file in
+// blocking mode for good; if switching it back fails f is returned as it is.
+func pollable(f *os.File) *os.File {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return f
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return f
+	}
+	_ = f.Close()
+	return os.NewFile(uintptr(fd), f.Name())
 }
 
-func (s *SubProcess) Start() error {
-	p, err := pty.Start(s.command)
+// startWithPty is what Start uses unless WithPtyFunc says otherwise. It is
+// pty.Start, except that putting the child in a new session with the pty as
+// its controlling terminal depends on WithSetsid.
+func (s *SubProcess) startWithPty(cmd *exec.Cmd) (*os.File, error) {
+	p, tty, err := pty.Open()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	s.pty = p
-	s.oldState, err = terminal.MakeRaw(int(os.Stdin.Fd()))
-	return err
+	defer tty.Close()
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = s.setsid
+	cmd.SysProcAttr.Setctty = s.setsid
+
+	if err := cmd.Start(); err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	return p, nil
 }
 
 func (s *SubProcess) Close() error {
-	defer func() {
-		_ = terminal.Restore(int(os.Stdin.Fd()), s.oldState)
-	}()
+	s.bufLock.Lock()
+	oldState, fd, killSignal := s.oldState, s.oldStateFd, s.killSignal
+	s.bufLock.Unlock()
+
+	if oldState != nil {
+		defer func() {
+			_ = terminal.Restore(fd, oldState)
+		}()
+	}
+	if s.command == nil && s.conn != nil {
+		return s.conn.Close()
+	}
+	if s.abandoned {
+		return nil
+	}
 	if s.command != nil && s.command.Process != nil {
-		return s.command.Process.Kill()
+		return s.command.Process.Signal(killSignal)
 	}
 	return nil
 }
 
+// SetKillSignal sets the signal Close sends to the child, SIGKILL by default.
+func (s *SubProcess) SetKillSignal(sig os.Signal) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.killSignal = sig
+}
+
+// SetArgv0 sets the name the child sees as argv[0], which is the command by
+// default, without changing the executable that is run. Multi-call binaries
+// such as busybox pick their behavior from it. It has no effect once the child
+// has started.
+func (s *SubProcess) SetArgv0(name string) {
+	if s.command == nil || s.command.Process != nil {
+		return
+	}
+	s.command.Args[0] = name
+}
+
+// Cmd returns the underlying command so that exec features without an option
+// of their own, such as SysProcAttr, can be set before Start. Start still sets
+// Stdin, Stdout and Stderr to the pty, and Setsid and Setctty as WithSetsid
+// says. Changing the command after Start is undefined. It returns nil when
+// there is no process behind the SubProcess, as with NewEchoSubProcess.
+func (s *SubProcess) Cmd() *exec.Cmd {
+	return s.command
+}
+
+// Wait waits for the child to exit and returns its exit error. It may be
+// called any number of times, from any goroutine. If the SubProcess context is
+// done first, the child is sent SIGTERM, then killed if it has not exited
+// within a few seconds, and the context error is returned.
+//
+// The child is reaped as soon as it exits whether or not Wait is called, so
+// it never lingers as a zombie.
+func (s *SubProcess) Wait() error {
+	if s.command == nil {
+		<-s.readDone
+		return nil
+	}
+	if s.abandoned {
+		<-s.done
+		return s.waitErr
+	}
+	if s.command.Process == nil {
+		return s.command.Wait()
+	}
+	<-s.done
+	return s.waitErr
+}
+
+// Done returns a channel that is closed once the child has exited and been
+// reaped.
+func (s *SubProcess) Done() <-chan struct{} {
+	return s.done
+}
+
+// State returns the exit state of the child, or nil while it is still
+// running or if it was never started.
+func (s *SubProcess) State() *os.ProcessState {
+	if s.command == nil {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return s.command.ProcessState
+	default:
+		return nil
+	}
+}
+
+// ExitCode returns the child's exit status as mapped by SetExitCodeMapper,
+// by default the way a shell reports it: the code it exited with, or 128 plus
+// the signal number if it was killed by a signal. It returns -1 while the
+// child is still running or if it was never started, so a wrapper can finish
+// with os.Exit(s.ExitCode()) after Interact.
+func (s *SubProcess) ExitCode() int {
+	state := s.State()
+	if state == nil {
+		return -1
+	}
+
+	s.bufLock.Lock()
+	mapper := s.exitCodeMapper
+	s.bufLock.Unlock()
+	if mapper == nil {
+		mapper = ShellExitCode
+	}
+	return mapper(state)
+}
+
+// reap waits for the child from Start onwards, enforcing the SubProcess
+// context, and publishes the result through done.
+func (s *SubProcess) reap() {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- s.command.Wait()
+	}()
+
+	var overdue <-chan time.Time
+	if s.maxRuntime > 0 {
+		timer := time.NewTimer(s.maxRuntime)
+		defer timer.Stop()
+		overdue = timer.C
+	}
+
+	select {
+	case s.waitErr = <-exited:
+	case <-s.ctx.Done():
+		s.terminate(exited)
+		s.waitErr = s.ctx.Err()
+	case <-overdue:
+		err := &RuntimeExceededError{Limit: s.maxRuntime}
+		s.bufLock.Lock()
+		s.runtimeErr = err
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.bufLock.Unlock()
+
+		s.terminate(exited)
+		s.waitErr = err
+	}
+	close(s.done)
+
+	attrs := []slog.Attr{slog.Int("code", s.ExitCode())}
+	if s.waitErr != nil {
+		attrs = append(attrs, slog.String("error", s.waitErr.Error()))
+	}
+	s.event(slog.LevelInfo, "exited", attrs...)
+
+	if s.exitEndsOutput {
+		// let the reader pick up what the child wrote just before exiting
+		select {
+		case <-s.readDone:
+		case <-time.After(exitDrainPeriod):
+		}
+
+		s.bufLock.Lock()
+		s.exited = true
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.bufLock.Unlock()
+	}
+}
+
+// terminate sends SIGTERM to the child and escalates to SIGKILL if it has not
+// exited, as reported on exited, within killGracePeriod.
+func (s *SubProcess) terminate(exited <-chan error) {
+	_ = s.command.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-exited:
+	case <-time.After(killGracePeriod):
+		_ = s.command.Process.Kill()
+		<-exited
+	}
+}
+
+// Write sends p to the child, retrying short writes until all of p has been
+// written or an error occurs, and reports how many bytes were written.
+func (s *SubProcess) Write(p []byte) (int, error) {
+	return s.write(context.Background(), p)
+}
+
+// write is Write that gives up once ctx is done, see SendContext.
+func (s *SubProcess) write(ctx context.Context, p []byte) (int, error) {
+	if s.conn == nil {
+		return 0, errNotStarted
+	}
+
+	select {
+	case s.writeLock <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() {
+		<-s.writeLock
+	}()
+
+	// the deadline is on the pty, shared by every writer, so it is only armed
+	// while this write holds the lock
+	if ctx.Done() != nil {
+		disarm := s.armWriteDeadline(ctx)
+		defer disarm()
+	}
+
+	s.bufLock.Lock()
+	s.expectEchoLocked(p)
+	s.logInputLocked(p)
+	threshold := s.stallThreshold
+	records := s.records
+	s.bufLock.Unlock()
+
+	if threshold > 0 {
+		stop := s.watchForStall(threshold, len(p))
+		defer stop()
+	}
+
+	var written int
+	defer func() {
+		if records != nil {
+			s.bufLock.Lock()
+			data := s.capLocked(&s.recorded, s.redactLocked(p[:written]))
+			s.bufLock.Unlock()
+			records.record(Input, data)
+		}
+		s.event(slog.LevelDebug, "wrote", slog.Int("bytes", written))
+	}()
+	for written < len(p) {
+		n, err := s.conn.Write(p[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Send writes value in full, see Write.
 func (s *SubProcess) Send(value string) error {
-	_, err := s.pty.Write([]byte(value))
+	_, err := s.SendBytes([]byte(value))
 	return err
 }
 
+// SendBytes writes value in full and returns the number of bytes written,
+// which is less than len(value) only if err is not nil.
+func (s *SubProcess) SendBytes(value []byte) (int, error) {
+	return s.Write(value)
+}
+
 func (s *SubProcess) SendLine(value string) error {
 	return s.Send(value + "\r\n")
 }
 
+// Sendf formats according to format, like fmt.Sprintf, and sends the result.
+func (s *SubProcess) Sendf(format string, args ...interface{}) error {
+	return s.Send(fmt.Sprintf(format, args...))
+}
+
+// SendLinef is Sendf followed by the line ending SendLine uses.
+func (s *SubProcess) SendLinef(format string, args ...interface{}) error {
+	return s.SendLine(fmt.Sprintf(format, args...))
+}
+
 func (s *SubProcess) ExpectWithTimeout(expression *regexp.Regexp, duration time.Duration) (bool, error) {
 	expressions := []*regexp.Regexp{
 		expression,
@@ -134,81 +747,369 @@ func (s *SubProcess) ExpectWithTimeout(expression *regexp.Regexp, duration time.
 }
 
 func (s *SubProcess) Expect(expression *regexp.Regexp) (bool, error) {
-	return s.ExpectWithTimeout(expression, DefaultTimeout)
+	return s.ExpectWithTimeout(expression, s.expectTimeout())
 }
 
 func (s *SubProcess) ExpectExpressions(expressions []*regexp.Regexp) (int, error) {
-	return s.ExpectExpressionsWithTimeout(expressions, DefaultTimeout)
+	return s.ExpectExpressionsWithTimeout(expressions, s.expectTimeout())
 }
 
-func (s *SubProcess) ExpectExpressionsWithTimeout(expressions []*regexp.Regexp, timeout time.Duration) (int, error) {
-	errs := make(chan error, 1)
-	ctx, _ := context.WithDeadline(context.Background(), time.Now().Add(timeout))
-	ctx, cancelFunc := context.WithCancel(ctx)
+// SetDefaultTimeout sets the timeout used by Expect and ExpectExpressions,
+// taking precedence over SUBPROCESS_DEFAULT_TIMEOUT. Zero restores the
+// package default.
+func (s *SubProcess) SetDefaultTimeout(timeout time.Duration) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.timeout = timeout
+}
 
-	var output bytes.Buffer
-	var rwLock sync.RWMutex
+func (s *SubProcess) expectTimeout() time.Duration {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	if s.timeout > 0 {
+		return s.timeout
+	}
+	return defaultTimeout
+}
 
-	var wg sync.WaitGroup
+func (s *SubProcess) ExpectExpressionsWithTimeout(expressions []*regexp.Regexp, timeout time.Duration) (int, error) {
+	return s.expectExpressions(expressions, timeout, true)
+}
 
-	wg.Add(1)
-	go s.readOutput(ctx, &wg, &output, &rwLock, errs)
+// expectExpressions is ExpectExpressionsWithTimeout, leaving out the lines
+// matched by SetIgnorePrompt when skipIgnored is set.
+func (s *SubProcess) expectExpressions(expressions []*regexp.Regexp, timeout time.Duration, skipIgnored bool) (int, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return s.expectUntil(context.Background(), expressions, timer.C, 0, skipIgnored)
+}
 
+// expectUntil is what all the expression matching comes down to, with the
+// bounds of waitUntil.
+func (s *SubProcess) expectUntil(ctx context.Context, expressions []*regexp.Regexp, deadline <-chan time.Time, idle time.Duration, skipIgnored bool) (int, error) {
 	var index = -1
-	var e error
+	var scanned = -1
+	started := time.Now()
+	err := s.waitUntil(ctx, deadline, idle, func(b []byte) int {
+		for i, r := range expressions {
+			if end := s.findEndLocked(r, b, skipIgnored); end >= 0 {
+				index = i
+				return end
+			}
+		}
+		if len(b) != scanned {
+			scanned = len(b)
+			s.debugNoMatchLocked(expressions, b)
+		}
+		return -1
+	})
+	if err == ErrTimeout {
+		err = timeoutError(expressions, started)
+	}
+	return index, err
+}
+
+func timeoutError(expressions []*regexp.Regexp, started time.Time) *TimeoutError {
+	patterns := make([]string, len(expressions))
+	for i, r := range expressions {
+		patterns[i] = r.String()
+	}
+	return &TimeoutError{Patterns: patterns, Elapsed: time.Since(started)}
+}
+
+// waitFor calls match with the buffered output every time it changes, until
+// match returns the end of a match in the buffer, reading from the pty fails
+// or the timeout elapses. match is called with bufLock held and must not
+// retain the slice; it returns -1 while there is no match.
+func (s *SubProcess) waitFor(timeout time.Duration, match func([]byte) int) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return s.waitUntil(context.Background(), timer.C, 0, match)
+}
+
+// waitUntil is waitFor with the overall bound given as a channel, which may
+// be nil for no bound at all, plus an optional idle bound: when idle is
+// positive and no output arrives for that long it gives up with
+// ErrIdleTimeout. It also gives up with ctx.Err() once ctx is done.
+func (s *SubProcess) waitUntil(ctx context.Context, deadline <-chan time.Time, idle time.Duration, match func([]byte) int) (err error) {
+	defer func() {
+		s.recordResult(err)
+	}()
+	return s.await(ctx, deadline, idle, match)
+}
+
+// await is waitUntil without recording the outcome as the LastExpectResult,
+// for waits that are only part of an Expect.
+func (s *SubProcess) await(ctx context.Context, deadline <-chan time.Time, idle time.Duration, match func([]byte) int) error {
+	var overall <-chan time.Time
+	if left, ok := s.TimeLeft(); ok {
+		timer := time.NewTimer(left)
+		defer timer.Stop()
+		overall = timer.C
+	}
+
+	var idleTimer *time.Timer
+	var quiet <-chan time.Time
+	if idle > 0 {
+		idleTimer = time.NewTimer(idle)
+		defer idleTimer.Stop()
+		quiet = idleTimer.C
+	}
 
-OUTER:
+	// the buffer is scanned before waiting for anything, so output that is
+	// already there matches at once, even with a zero timeout
 	for {
+		s.bufLock.Lock()
+		end := match(s.buf.Bytes())
+		if end >= 0 {
+			s.matchedLocked(end)
+		}
+		readErr, updated, exited := s.readErr, s.updated, s.exited
+		runtimeErr := s.runtimeErr
+		s.bufLock.Unlock()
+
+		if end >= 0 {
+			return nil
+		}
+
+		if runtimeErr != nil {
+			return runtimeErr
+		}
+
+		if isEOF(readErr) || exited {
+			return ErrClosed
+		}
+		if readErr != nil {
+			s.log.Printf("error reading from pty: %v", readErr)
+			return errors.Wrap(readErr, "error reading from pty")
+		}
+
 		select {
-		case <-ctx.Done():
-			e = ErrTimeout
-
-		case err := <-errs:
-			s.log.Printf("error reading from pty: %v", err)
-			e = errors.Wrap(err, "error reading from pty")
-			break OUTER
-
-		case <-time.After(50 * time.Microsecond): // TODO: adjust this
-			rwLock.RLock()
-			b := output.Bytes()
-			rwLock.RUnlock()
-
-			for i, r := range expressions {
-				if r.Find(b) != nil {
-					index = i
-					break OUTER
+		case <-updated:
+			if idleTimer != nil {
+				if !idleTimer.Stop() {
+					<-idleTimer.C
 				}
+				idleTimer.Reset(idle)
 			}
+		case <-deadline:
+			return ErrTimeout
+		case <-overall:
+			return ErrTimeout
+		case <-quiet:
+			return ErrIdleTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// findLocked returns the location of the first match of expression in b, or
+// nil, honoring anchored matching. bufLock must be held.
+func (s *SubProcess) findLocked(expression *regexp.Regexp, b []byte) []int {
+	loc := expression.FindIndex(b)
+	if loc != nil && s.anchored && loc[0] != 0 {
+		return nil
+	}
+	return loc
+}
+
+// findSubmatchLocked is findLocked for callers that need the submatches too.
+// It returns copies that remain valid once bufLock is released, and the end
+// of the match.
+func (s *SubProcess) findSubmatchLocked(expression *regexp.Regexp, b []byte) ([][]byte, int) {
+	loc := expression.FindSubmatchIndex(b)
+	if loc == nil || (s.anchored && loc[0] != 0) {
+		return nil, -1
+	}
+	return copySubmatches(b, loc), loc[1]
+}
+
+// copySubmatches copies the submatches at loc, as returned by
+// FindSubmatchIndex, out of b.
+func copySubmatches(b []byte, loc []int) [][]byte {
+	submatches := make([][]byte, len(loc)/2)
+	for i := range submatches {
+		if loc[2*i] >= 0 {
+			submatches[i] = append([]byte(nil), b[loc[2*i]:loc[2*i+1]]...)
 		}
 	}
+	return submatches
+}
 
-	cancelFunc()
-	wg.Wait()
-	return index, e
+// matchedLocked records a match ending at end in the buffer, consuming up to
+// it unless consumption is turned off. bufLock must be held.
+func (s *SubProcess) matchedLocked(end int) {
+	if s.consumed+end > s.lastMatchEnd {
+		s.lastMatchEnd = s.consumed + end
+	}
+	if s.consumeOnMatch {
+		s.rememberLocked(s.buf.Next(end))
+		s.consumed += end
+	}
 }
 
-func (s *SubProcess) readOutput(ctx context.Context, wg *sync.WaitGroup, buf io.Writer, lock *sync.RWMutex, errs chan error) {
-	defer wg.Done()
+// readOutput is the only reader of the pty. It runs from Start until the
+// pty returns an error, buffering output for Expect or handing it to the
+// forward writer while Interact is running.
+func (s *SubProcess) readOutput() {
+	defer close(s.readDone)
+	defer s.endOutputChan()
 
+	chunk := make([]byte, 4096)
+	var lastIgnored string
+	ignored := 0
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			var temp bytes.Buffer
-
-			n, err := io.Copy(&temp, s.pty)
-			if err != nil && err != io.EOF {
-				errs <- err
-				close(errs)
-				return
+		n, err := s.conn.Read(chunk)
+		if err == nil {
+			ignored = 0
+		} else if !isEOF(err) && !s.abortOnReadError(err) {
+			if err.Error() == lastIgnored {
+				ignored++
+			} else {
+				lastIgnored, ignored = err.Error(), 1
+			}
+			if ignored < maxIgnoredReadErrors {
+				s.log.Printf("ignoring error reading from pty: %v", err)
+				err = nil
+				// an error that keeps coming back is retried less and less
+				// often rather than in a tight loop
+				time.Sleep(time.Duration(1<<uint(ignored)) * time.Millisecond)
+			} else {
+				s.log.Printf("giving up after %d identical errors reading from pty: %v", ignored, err)
 			}
+		}
 
-			if n > 0 {
-				lock.Lock()
-				_, _ = buf.Write(temp.Bytes())
-				lock.Unlock()
+		s.bufLock.Lock()
+		forward, transcript, tap, onLine := s.forward, s.transcriptOut, s.tap, s.onLine
+		rotating, _ := s.transcript.(*rotatingWriter)
+		output, records := s.output, s.records
+		subscribers, audits := s.subscribers, s.audits
+		if n > 0 && s.capture != nil {
+			_, _ = s.capture.Write(s.capLocked(&s.captured, chunk[:n]))
+		}
+		var transcribe, record []byte
+		if n > 0 && transcript != nil {
+			transcribe = s.capLocked(&s.transcribed, chunk[:n])
+		}
+		if n > 0 && records != nil {
+			record = s.capLocked(&s.recorded, chunk[:n])
+		}
+		if n > 0 && s.screen != nil {
+			s.screen.feed(chunk[:n])
+		}
+		if n > 0 && s.firstByteAt.IsZero() {
+			s.firstByteAt = time.Now()
+		}
+		if n > 0 && forward == nil && output == nil {
+			b := chunk[:n]
+			if s.stripANSI {
+				b = s.ansi.strip(b)
+			}
+			if len(s.pendingEcho) > 0 {
+				b = s.stripEchoLocked(b)
+			}
+			_, _ = s.buf.Write(b)
+		}
+		dropped, onTrim := s.trimLocked(), s.onTrim
+		if err != nil {
+			s.readErr = err
+		}
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.bufLock.Unlock()
+
+		if dropped > 0 && onTrim != nil {
+			onTrim(dropped)
+		}
+
+		if n > 0 {
+			s.event(slog.LevelDebug, "read", slog.Int("bytes", n))
+		}
+		records.record(Output, record)
+		for _, sub := range subscribers {
+			sub.feed(chunk[:n], err != nil)
+		}
+		if n > 0 {
+			for _, a := range audits {
+				a.scan(chunk[:n])
 			}
 		}
+		if len(transcribe) > 0 {
+			_, _ = transcript.Write(transcribe)
+		}
+		if n > 0 && forward != nil {
+			_, _ = forward.Write(chunk[:n])
+		} else if n > 0 && tap != nil {
+			_, _ = tap.Write(chunk[:n])
+		}
+		if n > 0 && output != nil {
+			output <- append([]byte(nil), chunk[:n]...)
+		}
+		if onLine != nil {
+			s.feedLines(chunk[:n], err != nil, onLine)
+		}
+
+		if err != nil {
+			if rotating != nil {
+				_ = rotating.Close()
+			}
+			return
+		}
 	}
 }
+
+// maxIgnoredReadErrors is how many times in a row the same error reading the
+// pty is ignored before reading stops anyway.
+const maxIgnoredReadErrors = 10
+
+// OnReadError sets a policy for errors reading the pty other than the child
+// going away: if policy returns false the error is ignored and reading
+// continues, otherwise reading stops and pending and future Expects fail with
+// the error. Without a policy every such error stops reading. Retries after an
+// ignored error back off, and the same error ten times in a row stops reading
+// whatever the policy says.
+func (s *SubProcess) OnReadError(policy func(err error) bool) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.onReadError = policy
+}
+
+func (s *SubProcess) abortOnReadError(err error) bool {
+	s.bufLock.Lock()
+	policy := s.onReadError
+	s.bufLock.Unlock()
+	return policy == nil || policy(err)
+}
+
+// isEOF reports whether err from reading the pty means the child is gone.
+// Linux reports this as EIO once the last process holding the tty exits, and
+// the pty itself may have been closed at the end of Interact.
+func isEOF(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	pathErr, ok := err.(*os.PathError)
+	return ok && (pathErr.Err == syscall.EIO || pathErr.Err == os.ErrClosed)
+}
+
+// SetTap mirrors everything read from the pty to w, for example os.Stdout to
+// watch a scripted session, while it stays available to Expect. Unlike
+// Interact, nothing is read from stdin and the terminal is left alone. The
+// tap is skipped while Interact is running, since it already shows the
+// output. Writing to w holds up reading, so it should be quick.
+func (s *SubProcess) SetTap(w io.Writer) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.tap = w
+}
+
+// setForward sends pending and future output to w instead of buffering it.
+func (s *SubProcess) setForward(w io.Writer) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	_, _ = w.Write(s.buf.Bytes())
+	s.consumed += s.buf.Len()
+	s.buf.Reset()
+	s.forward = w
+}
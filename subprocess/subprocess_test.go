@@ -0,0 +1,119 @@
+package subprocess_test
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func startCat(t *testing.T) *subprocess.SubProcess {
+	t.Helper()
+	s, err := subprocess.NewSubProcess("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestConcurrentSendExpect(t *testing.T) {
+	s := startCat(t)
+
+	const lines = 100
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < lines; i++ {
+			if err := s.SendLine(fmt.Sprintf("line %d", i)); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	for i := 0; i < lines; i++ {
+		expression := regexp.MustCompile(fmt.Sprintf(`line %d\r`, i))
+		if ok, err := s.ExpectWithTimeout(expression, 5*time.Second); !ok {
+			t.Fatalf("line %d: %v", i, err)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentUse runs the calls that shared state goes through from
+// several goroutines at once; it is meant for go test -race.
+func TestConcurrentUse(t *testing.T) {
+	s := startCat(t)
+
+	ticks, cancel := s.Subscribe(regexp.MustCompile(`tick (\d+)\r`))
+	defer cancel()
+	subscribed := make(chan int)
+	go func() {
+		n := 0
+		for range ticks {
+			n++
+		}
+		subscribed <- n
+	}()
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := s.SendLinef("tick %d", i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		never := regexp.MustCompile(`never`)
+		for i := 0; i < rounds; i++ {
+			_, _ = s.TryExpect(never)
+			_ = s.LastExpectResult()
+			_ = s.Buffer()
+			_ = s.LastMatchEnd()
+			_ = s.LogOutput()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			s.SetDefaultTimeout(5 * time.Second)
+			s.SetHistory(i)
+			s.SetName(fmt.Sprintf("cat%d", i))
+			s.SetLogInput(i%2 == 0)
+			s.SetMaxBuffer(1 << 20)
+			s.SetDebugLogger(nil)
+		}
+	}()
+
+	for i := 0; i < rounds; i++ {
+		expression := regexp.MustCompile(fmt.Sprintf(`tick %d\r`, i))
+		if ok, err := s.Expect(expression); !ok {
+			t.Fatalf("tick %d: %v", i, err)
+		}
+	}
+	wg.Wait()
+
+	// each tick shows up twice, echoed by the pty and then written by cat
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Wait()
+	if n := <-subscribed; n < rounds {
+		t.Errorf("subscriber saw %d ticks, want at least %d", n, rounds)
+	}
+}
@@ -0,0 +1,26 @@
+// Package subprocesstest helps test code that matches the output of a
+// SubProcess, without running anything.
+package subprocesstest
+
+import (
+	"expect/subprocess"
+	"expect/subprocess/internal/testhook"
+)
+
+// SetBuffer replaces the unmatched output of s with b: Expect on an unstarted
+// SubProcess then matches against b as if the child had printed it. What it
+// replaces counts as consumed, so offsets such as LastMatchEnd carry on from
+// there.
+func SetBuffer(s *subprocess.SubProcess, b []byte) {
+	testhook.SetBuffer(s, b)
+}
+
+// New returns an unstarted SubProcess whose output is b.
+func New(b []byte) (*subprocess.SubProcess, error) {
+	s, err := subprocess.NewSubProcess("true")
+	if err != nil {
+		return nil, err
+	}
+	SetBuffer(s, b)
+	return s, nil
+}
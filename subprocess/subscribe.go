@@ -0,0 +1,127 @@
+package subprocess
+
+import (
+	"regexp"
+	"sync"
+)
+
+// subscribeWindow is how much unmatched output a subscription keeps to
+// match against once more arrives.
+const subscribeWindow = 64 * 1024
+
+// subscriber is one Subscribe call. readOutput queues chunks for it and its
+// own goroutine does the matching, so a slow subscriber only holds up itself.
+type subscriber struct {
+	expression *regexp.Regexp
+	matches    chan [][]byte
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	mu      sync.Mutex
+	pending [][]byte
+	ended   bool
+	wake    chan struct{}
+}
+
+// Subscribe delivers the submatches of every occurrence of expression in the
+// output to the returned channel, independently of Expect and of other
+// subscriptions, so several goroutines can each wait for their own pattern.
+// Matching starts with the output that is buffered at the time of the call;
+// nothing is consumed, and empty matches are skipped. The channel is closed once the output ends or cancel
+// is called, and cancel may be called more than once.
+func (s *SubProcess) Subscribe(expression *regexp.Regexp) (<-chan [][]byte, func()) {
+	sub := &subscriber{
+		expression: expression,
+		matches:    make(chan [][]byte),
+		stop:       make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+
+	s.bufLock.Lock()
+	if s.buf.Len() > 0 {
+		sub.pending = append(sub.pending, append([]byte(nil), s.buf.Bytes()...))
+	}
+	if s.outputEnded {
+		sub.ended = true
+	} else {
+		s.subscribers = append(s.subscribers, sub)
+	}
+	s.bufLock.Unlock()
+
+	go sub.run()
+
+	cancel := func() {
+		sub.stopOnce.Do(func() {
+			close(sub.stop)
+		})
+		s.bufLock.Lock()
+		defer s.bufLock.Unlock()
+		// readOutput may be ranging over the old slice, so build a new one
+		kept := make([]*subscriber, 0, len(s.subscribers))
+		for _, other := range s.subscribers {
+			if other != sub {
+				kept = append(kept, other)
+			}
+		}
+		s.subscribers = kept
+	}
+	return sub.matches, cancel
+}
+
+// feed queues a chunk of output, or the end of it if ended is set.
+func (sub *subscriber) feed(chunk []byte, ended bool) {
+	sub.mu.Lock()
+	if len(chunk) > 0 {
+		sub.pending = append(sub.pending, append([]byte(nil), chunk...))
+	}
+	if ended {
+		sub.ended = true
+	}
+	sub.mu.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscriber) run() {
+	defer close(sub.matches)
+
+	var window []byte
+	for {
+		sub.mu.Lock()
+		pending, ended := sub.pending, sub.ended
+		sub.pending = nil
+		sub.mu.Unlock()
+
+		for _, chunk := range pending {
+			window = append(window, chunk...)
+		}
+		matched := 0
+		for _, loc := range sub.expression.FindAllSubmatchIndex(window, -1) {
+			if loc[1] == loc[0] {
+				continue
+			}
+			select {
+			case sub.matches <- copySubmatches(window, loc):
+			case <-sub.stop:
+				return
+			}
+			matched = loc[1]
+		}
+		window = window[matched:]
+		if extra := len(window) - subscribeWindow; extra > 0 {
+			window = append([]byte(nil), window[extra:]...)
+		}
+
+		if ended {
+			return
+		}
+		select {
+		case <-sub.wake:
+		case <-sub.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package subprocess_test
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+// collect gathers the whole matches sent on matches until it is closed.
+func collect(t *testing.T, matches <-chan [][]byte) []string {
+	t.Helper()
+	var all []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m, ok := <-matches:
+			if !ok {
+				return all
+			}
+			all = append(all, string(m[0]))
+		case <-timeout:
+			t.Error("subscription still open after 5s")
+			return all
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		script   string
+		patterns []string
+		want     string
+	}{
+		{"separate patterns", `printf 'a1 b2 a3'`, []string{`a\d`, `b\d`}, `[[a1 a3] [b2]]`},
+		{"same pattern", `printf 'a1 a2'`, []string{`a\d`, `a\d`}, `[[a1 a2] [a1 a2]]`},
+		{"overlapping patterns", `printf 'abc'`, []string{`ab`, `bc`}, `[[ab] [bc]]`},
+		{"split across reads", `printf 'k=1 k='; sleep 0.1; printf '2'`, []string{`k=\d`}, `[[k=1 k=2]]`},
+		{"empty matches skipped", `printf 'axxbx'`, []string{`x*`}, `[[xx x]]`},
+		{"none", `printf 'nothing'`, []string{`\d`}, `[[]]`},
+	} {
+		s, err := subprocess.NewSubProcess("sh", "-c", tc.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var subs []<-chan [][]byte
+		for _, p := range tc.patterns {
+			matches, cancel := s.Subscribe(regexp.MustCompile(p))
+			defer cancel()
+			subs = append(subs, matches)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		// every subscriber is drained at once, as none waits for another
+		got := make([][]string, len(subs))
+		var wg sync.WaitGroup
+		for i, matches := range subs {
+			wg.Add(1)
+			go func(i int, matches <-chan [][]byte) {
+				defer wg.Done()
+				got[i] = collect(t, matches)
+			}(i, matches)
+		}
+		wg.Wait()
+		_ = s.Close()
+
+		if fmt.Sprint(got) != tc.want {
+			t.Errorf("%s: subscribers got %v, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSubscribeBufferedAndCancel(t *testing.T) {
+	s := seeded(t, "ready ready")
+	matches, cancel := s.Subscribe(regexp.MustCompile(`ready`))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-matches:
+		case <-time.After(time.Second):
+			t.Fatalf("buffered match %d not delivered", i)
+		}
+	}
+	cancel()
+	cancel()
+	if got := collect(t, matches); len(got) != 0 {
+		t.Errorf("got %q after cancel", got)
+	}
+	// subscribing consumes nothing
+	if ok, err := s.ExpectWithTimeout(regexp.MustCompile(`ready ready`), short); !ok {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,144 @@
+package subprocess
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var errNotStarted = errors.New("process not started")
+
+// Echo reports whether the pty currently echoes input back, i.e. whether
+// anything sent will also show up in the output.
+func (s *SubProcess) Echo() (bool, error) {
+	t, err := s.getTermios()
+	if err != nil {
+		return false, err
+	}
+	return t.Lflag&unix.ECHO != 0, nil
+}
+
+// SetEcho turns input echo on the pty on or off, for example before sending
+// a password.
+func (s *SubProcess) SetEcho(echo bool) error {
+	t, err := s.getTermios()
+	if err != nil {
+		return err
+	}
+	if echo {
+		t.Lflag |= unix.ECHO
+	} else {
+		t.Lflag &^= unix.ECHO
+	}
+	return s.setTermios(t)
+}
+
+// getTermios reads the terminal attributes of the pty. It goes through
+// SyscallConn rather than Fd so the pty stays in non-blocking mode.
+func (s *SubProcess) getTermios() (*unix.Termios, error) {
+	if s.pty == nil {
+		return nil, errNotStarted
+	}
+	conn, err := s.pty.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var t *unix.Termios
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		t, ioctlErr = unix.IoctlGetTermios(int(fd), ioctlGetTermios)
+	}); err != nil {
+		return nil, err
+	}
+	return t, errors.Wrap(ioctlErr, "error reading terminal attributes")
+}
+
+func (s *SubProcess) setTermios(t *unix.Termios) error {
+	if s.pty == nil {
+		return errNotStarted
+	}
+	conn, err := s.pty.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetTermios(int(fd), ioctlSetTermios, t)
+	}); err != nil {
+		return err
+	}
+	return errors.Wrap(ioctlErr, "error setting terminal attributes")
+}
+
+// inheritSize copies the window size of the terminal on from to the pty, like
+// pty.InheritSize, without switching the pty to blocking mode.
+func (s *SubProcess) inheritSize(from *os.File) error {
+	fd, err := rawFd(from)
+	if err != nil {
+		return err
+	}
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	conn, err := s.pty.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, size)
+	}); err != nil {
+		return err
+	}
+	return ioctlErr
+}
+
+// TTYName returns the name of the terminal device the child has as its
+// controlling terminal, such as /dev/pts/3, as shown by tools like ps.
+func (s *SubProcess) TTYName() (string, error) {
+	if s.pty == nil {
+		return "", errNotStarted
+	}
+	conn, err := s.pty.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	var nameErr error
+	if err := conn.Control(func(fd uintptr) {
+		name, nameErr = ptsName(int(fd))
+	}); err != nil {
+		return "", err
+	}
+	if nameErr == nil {
+		return name, nil
+	}
+	if s.command != nil {
+		if tty, ok := s.command.Stdin.(*os.File); ok {
+			return tty.Name(), nil
+		}
+	}
+	return "", errors.Wrap(nameErr, "error reading pty name")
+}
+
+// rawFd returns the descriptor of f without switching f to blocking mode, as
+// Fd does, so that reads from f can still be interrupted.
+func rawFd(f *os.File) (int, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := conn.Control(func(u uintptr) {
+		fd = int(u)
+	}); err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,20 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package subprocess
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
+
+// ptsName is not implemented here; TTYName falls back to the file the child
+// was given.
+func ptsName(fd int) (string, error) {
+	return "", errors.New("no way to look up the pty name on this platform")
+}
@@ -0,0 +1,22 @@
+package subprocess
+
+import "expect/subprocess/internal/testhook"
+
+func init() {
+	testhook.SetBuffer = func(s interface{}, b []byte) {
+		s.(*SubProcess).setTestBuffer(b)
+	}
+}
+
+// setTestBuffer replaces the unmatched output with b as if the child had
+// printed it. What it replaces counts as consumed, so offsets such as
+// LastMatchEnd carry on from there.
+func (s *SubProcess) setTestBuffer(b []byte) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.consumed += s.buf.Len()
+	s.buf.Reset()
+	_, _ = s.buf.Write(b)
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
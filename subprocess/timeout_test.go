@@ -0,0 +1,45 @@
+package subprocess_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func TestTimeoutError(t *testing.T) {
+	s := subprocess.NewEchoSubProcess(nil)
+	defer s.Close()
+
+	_, err := s.ExpectWithTimeout(regexp.MustCompile(`never`), 10*time.Millisecond)
+	var timeout *subprocess.TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("ExpectWithTimeout returned %v, want a *TimeoutError", err)
+	}
+	if !errors.Is(err, subprocess.ErrTimeout) {
+		t.Error("*TimeoutError does not match ErrTimeout")
+	}
+	if len(timeout.Patterns) != 1 || timeout.Patterns[0] != "never" {
+		t.Errorf("Patterns is %q", timeout.Patterns)
+	}
+
+	_, err = s.ExpectContext(regexp.MustCompile(`never`), 10*time.Millisecond)
+	if !errors.Is(err, subprocess.ErrTimeout) {
+		t.Errorf("ExpectContext returned %v, want ErrTimeout", err)
+	}
+}
+
+func TestExpectPromptTimeoutError(t *testing.T) {
+	for _, lookback := range []int{0, 16} {
+		s := seeded(t, "no prompt here")
+		s.SetPromptLookback(lookback)
+
+		err := s.ExpectPrompt(short)
+		var timeout *subprocess.TimeoutError
+		if !errors.As(err, &timeout) {
+			t.Errorf("with lookback %d ExpectPrompt returned %v, want a *TimeoutError", lookback, err)
+		}
+	}
+}
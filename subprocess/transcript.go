@@ -0,0 +1,46 @@
+package subprocess
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// SetTranscript records the session to w: a short header describing the
+// command and the terminal, followed by all output read from the pty. The
+// header is written immediately if the process is running, otherwise by
+// Start. A transcript set up by SetRotatingTranscript that w replaces is
+// closed.
+func (s *SubProcess) SetTranscript(w io.Writer) {
+	s.bufLock.Lock()
+	old, _ := s.transcript.(*rotatingWriter)
+	s.transcript = w
+	s.transcriptOut = s.namedWriterLocked(w)
+	out := s.transcriptOut
+	s.bufLock.Unlock()
+
+	if old != nil && io.Writer(old) != w {
+		_ = old.Close()
+	}
+
+	if s.conn != nil && out != nil {
+		s.writeTranscriptHeader(out)
+	}
+}
+
+func (s *SubProcess) writeTranscriptHeader(w io.Writer) {
+	echo := "unknown"
+	if on, err := s.Echo(); err == nil && on {
+		echo = "on"
+	} else if err == nil {
+		echo = "off"
+	}
+
+	command := "(none)"
+	if s.command != nil {
+		command = s.CommandLine()
+	}
+
+	_, _ = fmt.Fprintf(w, "# command: %s\n# started: %s\n# echo: %s\n",
+		command, time.Now().Format(time.RFC3339), echo)
+}
@@ -0,0 +1,93 @@
+package subprocess
+
+import (
+	"context"
+	"regexp"
+)
+
+// watch is a pattern registered with AddPattern and the handler to call when
+// Watch sees it.
+type watch struct {
+	expression *regexp.Regexp
+	handler    func(match [][]byte)
+}
+
+// AddPattern registers expression with Watch; each time it matches, handler
+// is called with its submatches. Patterns can be added and removed while
+// Watch is running, including from a handler, and take effect from the next
+// scan of the output.
+func (s *SubProcess) AddPattern(expression *regexp.Regexp, handler func(match [][]byte)) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+	s.watches = append(s.watches, watch{expression: expression, handler: handler})
+}
+
+// RemovePattern unregisters every handler added for expression.
+func (s *SubProcess) RemovePattern(expression *regexp.Regexp) {
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	kept := s.watches[:0]
+	for _, w := range s.watches {
+		if w.expression != expression {
+			kept = append(kept, w)
+		}
+	}
+	s.watches = kept
+}
+
+// Watch matches the registered patterns against the output as it arrives
+// until ctx is done or the child goes away. Whenever several patterns match,
+// the one that starts earliest wins; output is consumed through its match
+// before its handler runs, on the goroutine that called Watch. Each
+// occurrence is handled once, even when matches are not consumed, and
+// patterns that match the empty string only count where they match
+// something.
+func (s *SubProcess) Watch(ctx context.Context) error {
+	// next is the offset into the output the search resumes from, for when
+	// matches are not consumed
+	next := 0
+	for {
+		var matched watch
+		var submatches [][]byte
+
+		err := s.waitUntil(ctx, nil, 0, func(b []byte) int {
+			start := next - s.consumed
+			if start < 0 {
+				start = 0
+			}
+			var first []int
+			for _, w := range s.watches {
+				loc := firstNonEmpty(w.expression, b[start:])
+				if loc == nil || (s.anchored && loc[0] != 0) {
+					continue
+				}
+				if first == nil || loc[0] < first[0] {
+					first, matched = loc, w
+				}
+			}
+			if first == nil {
+				return -1
+			}
+			submatches = copySubmatches(b[start:], first)
+			next = s.consumed + start + first[1]
+			return start + first[1]
+		})
+		if err != nil {
+			return err
+		}
+
+		matched.handler(submatches)
+	}
+}
+
+// firstNonEmpty returns the submatch indexes of the first match of
+// expression in b that is not empty, or nil.
+func firstNonEmpty(expression *regexp.Regexp, b []byte) []int {
+	for _, loc := range expression.FindAllSubmatchIndex(b, -1) {
+		if loc[1] > loc[0] {
+			return loc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package subprocess_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"expect/subprocess"
+)
+
+func TestWatchWithoutConsuming(t *testing.T) {
+	s := subprocess.NewEchoSubProcess(nil)
+	defer s.Close()
+	s.SetConsumeOnMatch(false)
+
+	seen := 0
+	s.AddPattern(regexp.MustCompile(`ping`), func([][]byte) { seen++ })
+	if err := s.Send("ping pong ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Watch(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Watch returned %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after its context ended")
+	}
+	if seen != 2 {
+		t.Errorf("handler called %d times, want 2", seen)
+	}
+}